@@ -0,0 +1,44 @@
+package main
+
+import (
+	"flag"
+
+	"github.com/olafal0/rescaffold/config"
+	"github.com/olafal0/rescaffold/scaffold"
+)
+
+var addCommand = &command{
+	name:    "add",
+	args:    "[flags] <source>[@ref]...",
+	summary: "generate scaffolds into the project",
+	description: `Add generates one or more scaffolds into the project, and records them in
+.rescaffold.toml. A source is a git URL or local directory, optionally followed
+by the subdirectory containing the scaffold (source//subdir) and a git ref
+(source@ref).`,
+	setup: setupAdd,
+}
+
+func setupAdd(flags *flag.FlagSet) func(args []string) error {
+	pf := addPlanFlags(flags, true)
+	return func(args []string) error {
+		if len(args) == 0 {
+			usageError(flags, "no scaffolds specified")
+		}
+		return pf.runPlans(func(lockfile *config.Lockfile, opts scaffold.Options) ([]*scaffold.Plan, error) {
+			return PlanGenerations(lockfile, args, pf.outputDir, opts)
+		})
+	}
+}
+
+func PlanGenerations(lockfile *config.Lockfile, scaffolds []string, outdir string, opts scaffold.Options) ([]*scaffold.Plan, error) {
+	plans := make([]*scaffold.Plan, 0, len(scaffolds))
+	for _, s := range scaffolds {
+		source, ref := scaffold.ParseSource(s)
+		plan, err := scaffold.PlanGenerate(lockfile, source, ref, outdir, opts)
+		if err != nil {
+			return nil, err
+		}
+		plans = append(plans, plan)
+	}
+	return plans, nil
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"time"
+
+	"github.com/olafal0/rescaffold/scaffold"
+)
+
+var cacheCommand = &command{
+	name:    "cache",
+	args:    "list | prune [-older-than duration]",
+	summary: "list or prune cached git sources",
+	description: `Cache lists the git sources cached in the user cache directory, with the
+commits checked out from each, or prunes them from the cache.`,
+	setup: setupCache,
+}
+
+func setupCache(flags *flag.FlagSet) func(args []string) error {
+	olderThan := flags.Duration("older-than", 0, "prune only revisions that haven't been used for this long, e.g. 720h. By default, the whole cache is pruned")
+	return func(args []string) error {
+		if len(args) == 0 {
+			usageError(flags, "no cache command specified")
+		}
+		// Flags follow the cache command, so they are parsed again
+		subcommand := args[0]
+		flags.Parse(args[1:])
+		if flags.NArg() > 0 {
+			usageError(flags, "too many arguments")
+		}
+
+		root, err := scaffold.CacheDir()
+		if err != nil {
+			return err
+		}
+		switch subcommand {
+		case "list":
+			return listCache(root)
+		case "prune":
+			removed, err := scaffold.PruneCache(root, *olderThan)
+			if err != nil {
+				return err
+			}
+			fmt.Printf("removed %d cached revisions\n", removed)
+			return nil
+		}
+		usageError(flags, fmt.Sprintf("unknown cache command %q", subcommand))
+		return nil
+	}
+}
+
+func listCache(root string) error {
+	sources, err := scaffold.ListCache(root)
+	if err != nil {
+		return err
+	}
+	if len(sources) == 0 {
+		fmt.Println("cache is empty")
+		return nil
+	}
+	for _, cached := range sources {
+		source := cached.Source
+		if source == "" {
+			source = "(incomplete) " + cached.Dir
+		}
+		fmt.Printf("%s (%s)\n", source, formatSize(cached.Size))
+		for _, commit := range cached.Commits {
+			fmt.Printf("  %s  last used %s\n", commit.Commit, commit.LastUsed.Format(time.DateTime))
+		}
+	}
+	return nil
+}
+
+// formatSize formats a size in bytes for display.
+func formatSize(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(size)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,12 @@
+//go:build !(darwin || dragonfly || freebsd || linux || netbsd || openbsd)
+
+package config
+
+import "os"
+
+// lockDir does nothing on platforms without flock, including Windows;
+// concurrent rescaffold processes are not prevented from using the same
+// lockfile.
+func lockDir(dir string, shared bool) (*os.File, error) {
+	return nil, nil
+}
@@ -0,0 +1,36 @@
+//go:build darwin || dragonfly || freebsd || linux || netbsd || openbsd
+
+package config
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"syscall"
+)
+
+// lockDir takes an advisory lock on dir, waiting for any other process that
+// holds a conflicting lock. Shared locks can be held by several processes at
+// once, but not while another holds an exclusive lock. The lock is released
+// when the returned file is closed, or when the process exits.
+func lockDir(dir string, shared bool) (*os.File, error) {
+	f, err := os.Open(dir)
+	if err != nil {
+		return nil, err
+	}
+	how := syscall.LOCK_EX
+	if shared {
+		how = syscall.LOCK_SH
+	}
+	err = syscall.Flock(int(f.Fd()), how|syscall.LOCK_NB)
+	if errors.Is(err, syscall.EWOULDBLOCK) {
+		log.Printf("waiting for another rescaffold process using %s to finish\n", dir)
+		err = syscall.Flock(int(f.Fd()), how)
+	}
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("could not lock %s: %w", dir, err)
+	}
+	return f, nil
+}
@@ -2,29 +2,61 @@ package config
 
 import (
 	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"log"
 	"os"
 	"path"
+	"strconv"
 
 	"github.com/BurntSushi/toml"
 )
 
 const (
 	LockfileFilename = ".rescaffold.toml"
+	// LockfileVersion is the version of the lockfile format written by this
+	// version of rescaffold. It must be increased whenever the format changes,
+	// along with a migration from the previous version in lockfileMigrations.
+	LockfileVersion = 1
 )
 
+// ErrLockfileChanged is returned when a lockfile is written or removed after
+// another process changed it on disk.
+var ErrLockfileChanged = errors.New("lockfile was changed on disk since it was loaded")
+
 type Lockfile struct {
+	// Version is the version of the lockfile format
+	Version   int                          `toml:"lockfile_version"`
 	Scaffolds map[string]*LockfileScaffold `toml:"scaffolds"`
 
 	// filename is the filename from which this lockfile was loaded or created
 	filename     string
 	newlyCreated bool
+	// readOnly is set if the lockfile was read with ReadLockfile, and can't be
+	// written back to disk
+	readOnly bool
+	// lock holds the advisory lock on the lockfile's directory until the
+	// lockfile is closed
+	lock *os.File
+	// checksum is the checksum of the file as it was last read or written, so
+	// that changes made to it by other processes can be detected
+	checksum string
 }
 
 type LockfileScaffold struct {
-	Source string                  `toml:"source"`
+	// Source is the git URL or local directory containing the scaffold
+	Source string `toml:"source"`
+	// Subdir is the directory containing the scaffold, relative to the root of
+	// the source, if the scaffold isn't at the root
+	Subdir string `toml:"subdir,omitempty"`
+	// Ref is the git ref (tag, branch, or commit) requested for the source, if any
+	Ref string `toml:"ref,omitempty"`
+	// Commit is the resolved git commit that the scaffold files were generated from
+	Commit string                  `toml:"commit,omitempty"`
 	Files  []*LockfileScaffoldFile `toml:"file"`
 	Vars   map[string]string       `toml:"vars"`
 }
@@ -32,26 +64,118 @@ type LockfileScaffold struct {
 type LockfileScaffoldFile struct {
 	Path     string `toml:"path"`
 	Checksum string `toml:"checksum"`
+	// Mode is the file's permission bits in octal, e.g. "0755"
+	Mode string `toml:"mode,omitempty"`
+	// Symlink is set if the file is a symbolic link, in which case Checksum is
+	// the checksum of the link's target path
+	Symlink bool `toml:"symlink,omitempty"`
+}
+
+// SetMode records the mode of the file.
+func (f *LockfileScaffoldFile) SetMode(mode fs.FileMode) {
+	if mode&fs.ModeSymlink != 0 {
+		f.Mode = ""
+		f.Symlink = true
+		return
+	}
+	f.Mode = fmt.Sprintf("%04o", mode.Perm())
+	f.Symlink = false
+}
+
+// FileMode returns the recorded mode of the file. Files recorded without a mode
+// are assumed to be regular files with mode 0644.
+func (f *LockfileScaffoldFile) FileMode() fs.FileMode {
+	if f.Symlink {
+		return fs.ModeSymlink | 0777
+	}
+	perm, err := strconv.ParseUint(f.Mode, 8, 32)
+	if err != nil {
+		return 0644
+	}
+	return fs.FileMode(perm).Perm()
 }
 
 // LoadLockfile loads a lockfile from the given filename. If the file does not
 // exist, a new lockfile is created and returned.
 //
 // If a lockfile is returned, it will have an associated filename and can be
-// written back to disk.
-func LoadLockfile(filename string) (*Lockfile, error) {
-	f, err := os.Open(filename)
+// written back to disk. It holds an advisory lock on the lockfile's directory,
+// so that other rescaffold processes wait to use the lockfile until it is
+// closed.
+func LoadLockfile(filename string) (lockfile *Lockfile, err error) {
+	if err := os.MkdirAll(path.Dir(filename), 0755); err != nil {
+		return nil, err
+	}
+	lock, err := lockDir(path.Dir(filename), false)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err != nil && lock != nil {
+			lock.Close()
+		}
+	}()
+
+	data, err := os.ReadFile(filename)
 	if err != nil {
 		if os.IsNotExist(err) {
-			return CreateLockfile(filename)
+			return createLockfile(filename, lock)
 		}
 		return nil, fmt.Errorf("opening lockfile for reading failed: %w", err)
 	}
-	lockfile, err := parseLockfile(f)
+	lockfile, err = parseLockfile(bytes.NewReader(data))
 	if err != nil {
 		return nil, fmt.Errorf("parse lockfile failed: %w", err)
 	}
 	lockfile.filename = filename
+	lockfile.lock = lock
+	lockfile.checksum = checksum(data)
+	return lockfile, nil
+}
+
+// ReadLockfile loads a lockfile from the given filename without creating or
+// writing anything on disk. If the file does not exist, a new lockfile is
+// returned, as with LoadLockfile, but it is only held in memory.
+//
+// The returned lockfile can't be written back to disk. It holds a shared lock
+// on the lockfile's directory, if the directory exists, so that any number of
+// read-only lockfiles can be used at once, but not while a lockfile loaded by
+// LoadLockfile is in use. Like a loaded lockfile, it must be closed to release
+// its lock.
+func ReadLockfile(filename string) (lockfile *Lockfile, err error) {
+	var lock *os.File
+	if _, err := os.Stat(path.Dir(filename)); err == nil {
+		lock, err = lockDir(path.Dir(filename), true)
+		if err != nil {
+			return nil, err
+		}
+	}
+	defer func() {
+		if err != nil && lock != nil {
+			lock.Close()
+		}
+	}()
+
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			return nil, fmt.Errorf("opening lockfile for reading failed: %w", err)
+		}
+		if _, err := os.Stat(path.Join(path.Dir(filename), ManifestFilename)); !os.IsNotExist(err) {
+			return nil, fmt.Errorf("cannot create lockfile in %s, manifest file exists", path.Dir(filename))
+		}
+		lockfile = defaultLockfile()
+		lockfile.newlyCreated = true
+	} else {
+		lockfile, err = parseLockfile(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("parse lockfile failed: %w", err)
+		}
+		lockfile.checksum = checksum(data)
+	}
+	lockfile.filename = filename
+	lockfile.lock = lock
+	lockfile.readOnly = true
 	return lockfile, nil
 }
 
@@ -59,65 +183,136 @@ func LoadLockfile(filename string) (*Lockfile, error) {
 // filename.
 //
 // If a lockfile is returned, it will have an associated filename and can be
-// written back to disk.
+// written back to disk. Like a loaded lockfile, it must be closed to release
+// its lock.
 func CreateLockfile(filename string) (*Lockfile, error) {
 	if err := os.MkdirAll(path.Dir(filename), 0755); err != nil {
 		return nil, err
 	}
+	lock, err := lockDir(path.Dir(filename), false)
+	if err != nil {
+		return nil, err
+	}
+	lockfile, err := createLockfile(filename, lock)
+	if err != nil && lock != nil {
+		lock.Close()
+	}
+	return lockfile, err
+}
+
+// createLockfile creates a new default lockfile while holding lock.
+func createLockfile(filename string, lock *os.File) (*Lockfile, error) {
 	// Check for manifest file in the same directory, and fail if one is present
-	_, err := os.Open(path.Join(path.Dir(filename), ManifestFilename))
-	if !os.IsNotExist(err) {
+	if _, err := os.Stat(path.Join(path.Dir(filename), ManifestFilename)); !os.IsNotExist(err) {
 		return nil, fmt.Errorf("cannot create lockfile in %s, manifest file exists", path.Dir(filename))
 	}
-	f, err := os.Create(filename)
+	lockfile := defaultLockfile()
+	data, err := lockfile.Encode()
 	if err != nil {
 		return nil, err
 	}
-	defer f.Close()
-	lockfile := defaultLockfile()
-	if err := toml.NewEncoder(f).Encode(lockfile); err != nil {
+	if err := writeFileAtomic(filename, data); err != nil {
 		return nil, err
 	}
 
 	lockfile.filename = filename
 	lockfile.newlyCreated = true
+	lockfile.lock = lock
+	lockfile.checksum = checksum(data)
 	log.Printf("lockfile created: %s\n", filename)
 	return lockfile, nil
 }
 
-// WriteUpdated writes the lockfile back to disk in its current state
+// WriteUpdated writes the lockfile back to disk in its current state. The file
+// is replaced in a single step, so it is never left partially written. If the
+// file has been changed on disk since it was loaded, it is left alone and
+// ErrLockfileChanged is returned.
 func (l *Lockfile) WriteUpdated() error {
 	if l.filename == "" {
 		return fmt.Errorf("lockfile has unknown filename")
 	}
+	if l.readOnly {
+		return fmt.Errorf("lockfile %s was opened read-only", l.filename)
+	}
 	// Write lockfile to buffer so that, in the event of an encoding error, the
-	// lockfile is not truncated
-	buf := &bytes.Buffer{}
-	enc := toml.NewEncoder(buf)
-	enc.Indent = ""
-	err := enc.Encode(l)
+	// lockfile is not changed
+	data, err := l.Encode()
 	if err != nil {
-		return fmt.Errorf("failed to encode lockfile for update: %w", err)
+		return err
 	}
-
-	f, err := os.Create(l.filename)
-	if err != nil {
+	if err := l.CheckUnchanged(); err != nil {
 		return err
 	}
-	_, err = buf.WriteTo(f)
-	if err != nil {
+	if err := writeFileAtomic(l.filename, data); err != nil {
+		return err
+	}
+	l.checksum = checksum(data)
+	return nil
+}
+
+// CheckUnchanged returns ErrLockfileChanged if the lockfile on disk is no
+// longer the one that was loaded or last written, which means that another
+// process has changed it.
+func (l *Lockfile) CheckUnchanged() error {
+	current := ""
+	data, err := os.ReadFile(l.filename)
+	if err == nil {
+		current = checksum(data)
+	} else if !os.IsNotExist(err) {
 		return err
 	}
+	if current != l.checksum {
+		return fmt.Errorf("%s: %w", l.filename, ErrLockfileChanged)
+	}
 	return nil
 }
 
+// Encode returns the lockfile in the format it is written to disk
+func (l *Lockfile) Encode() ([]byte, error) {
+	buf := &bytes.Buffer{}
+	enc := toml.NewEncoder(buf)
+	enc.Indent = ""
+	err := enc.Encode(l)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode lockfile for update: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// Filename returns the filename from which this lockfile was loaded or created
+func (l *Lockfile) Filename() string {
+	return l.filename
+}
+
 func (l *Lockfile) IsNewlyCreated() bool {
 	return l.newlyCreated
 }
 
-// Remove removes the lockfile from disk permanently
+// Remove removes the lockfile from disk permanently. Like WriteUpdated, it
+// returns ErrLockfileChanged if the file has been changed on disk.
 func (l *Lockfile) Remove() error {
-	return os.Remove(l.filename)
+	if l.readOnly {
+		return fmt.Errorf("lockfile %s was opened read-only", l.filename)
+	}
+	if err := l.CheckUnchanged(); err != nil {
+		return err
+	}
+	if err := os.Remove(l.filename); err != nil {
+		return err
+	}
+	l.checksum = ""
+	return nil
+}
+
+// Close releases the lockfile's lock, allowing other processes to use it. The
+// lockfile can still be read, but should not be written after it is closed.
+func (l *Lockfile) Close() error {
+	if l.lock == nil {
+		return nil
+	}
+	err := l.lock.Close()
+	l.lock = nil
+	return err
 }
 
 // GetScaffold returns the lockfile information for a scaffold, initializing it
@@ -128,11 +323,7 @@ func (l *Lockfile) GetScaffold(source string, manifest *Manifest) *LockfileScaff
 		return ls
 	}
 
-	newLS := &LockfileScaffold{
-		Source: source,
-		Files:  []*LockfileScaffoldFile{},
-		Vars:   map[string]string{},
-	}
+	newLS := NewLockfileScaffold(source)
 	l.Scaffolds[source] = newLS
 	return newLS
 }
@@ -141,6 +332,31 @@ func (l *Lockfile) RemoveScaffold(source string) {
 	delete(l.Scaffolds, source)
 }
 
+// NewLockfileScaffold returns empty lockfile information for a scaffold, which
+// is not yet part of any lockfile.
+func NewLockfileScaffold(source string) *LockfileScaffold {
+	return &LockfileScaffold{
+		Source: source,
+		Files:  []*LockfileScaffoldFile{},
+		Vars:   map[string]string{},
+	}
+}
+
+// Clone returns a deep copy of the lockfile information for a scaffold.
+func (ls *LockfileScaffold) Clone() *LockfileScaffold {
+	clone := *ls
+	clone.Files = make([]*LockfileScaffoldFile, 0, len(ls.Files))
+	for _, f := range ls.Files {
+		fileCopy := *f
+		clone.Files = append(clone.Files, &fileCopy)
+	}
+	clone.Vars = make(map[string]string, len(ls.Vars))
+	for k, v := range ls.Vars {
+		clone.Vars[k] = v
+	}
+	return &clone
+}
+
 // GetFile returns the lockfile information for a file. If the file does not
 // exist, it returns nil.
 func (ls *LockfileScaffold) GetFile(path string) *LockfileScaffoldFile {
@@ -179,13 +395,23 @@ func (ls *LockfileScaffold) RemoveFile(path string) {
 
 func defaultLockfile() *Lockfile {
 	return &Lockfile{
+		Version:   LockfileVersion,
 		Scaffolds: map[string]*LockfileScaffold{},
 	}
 }
 
-func parseLockfile(data io.Reader) (*Lockfile, error) {
+func parseLockfile(r io.Reader) (*Lockfile, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	data, err = migrateLockfile(data)
+	if err != nil {
+		return nil, err
+	}
+
 	lockfile := &Lockfile{}
-	meta, err := toml.NewDecoder(data).Decode(lockfile)
+	meta, err := toml.NewDecoder(bytes.NewReader(data)).Decode(lockfile)
 	if err != nil {
 		return nil, err
 	}
@@ -196,3 +422,53 @@ func parseLockfile(data io.Reader) (*Lockfile, error) {
 
 	return lockfile, nil
 }
+
+// checksum returns the checksum of a lockfile's contents.
+func checksum(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// writeFileAtomic writes data to filename by way of a temporary file in the
+// same directory, which is synced and then renamed over filename, so that a
+// crash or interrupt leaves either the old or the new contents. The file keeps
+// its mode if it already exists.
+func writeFileAtomic(filename string, data []byte) (err error) {
+	mode := fs.FileMode(0644)
+	if info, err := os.Stat(filename); err == nil {
+		mode = info.Mode().Perm()
+	}
+	dir := path.Dir(filename)
+	f, err := os.CreateTemp(dir, "."+path.Base(filename)+".tmp-")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			f.Close()
+			os.Remove(f.Name())
+		}
+	}()
+	if _, err = f.Write(data); err != nil {
+		return err
+	}
+	if err = f.Chmod(mode); err != nil {
+		return err
+	}
+	if err = f.Sync(); err != nil {
+		return err
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+	if err = os.Rename(f.Name(), filename); err != nil {
+		return err
+	}
+	// Sync the directory so that the rename itself survives a crash. Not all
+	// platforms support this, so failures are ignored.
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+	return nil
+}
@@ -0,0 +1,246 @@
+package config_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/olafal0/rescaffold/assert"
+	"github.com/olafal0/rescaffold/config"
+)
+
+func TestLockfileWriteUpdated(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), config.LockfileFilename)
+	lockfile, err := config.LoadLockfile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, lockfile.IsNewlyCreated(), true)
+	lockfile.Scaffolds["example"] = config.NewLockfileScaffold("example")
+	if err := lockfile.WriteUpdated(); err != nil {
+		t.Fatal(err)
+	}
+	// Writing again is fine, since the file on disk is the one last written
+	if err := lockfile.WriteUpdated(); err != nil {
+		t.Fatal(err)
+	}
+	lockfile.Close()
+
+	// No temporary files are left behind
+	entries, err := os.ReadDir(filepath.Dir(filename))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, len(entries), 1)
+
+	reloaded, err := config.LoadLockfile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reloaded.Close()
+	assert.Equal(t, reloaded.IsNewlyCreated(), false)
+	assert.Equal(t, reloaded.Scaffolds["example"].Source, "example")
+}
+
+func TestReadLockfile(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "project")
+	filename := filepath.Join(dir, config.LockfileFilename)
+	lockfile, err := config.ReadLockfile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, lockfile.IsNewlyCreated(), true)
+	lockfile.Scaffolds["example"] = config.NewLockfileScaffold("example")
+	if err := lockfile.WriteUpdated(); err == nil {
+		t.Error("expected an error writing a read-only lockfile")
+	}
+	lockfile.Close()
+	// Neither the lockfile nor its directory are created
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		t.Fatalf("expected %s not to exist, got %v", dir, err)
+	}
+
+	created, err := config.LoadLockfile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	created.Scaffolds["example"] = config.NewLockfileScaffold("example")
+	if err := created.WriteUpdated(); err != nil {
+		t.Fatal(err)
+	}
+	created.Close()
+	lockfile, err = config.ReadLockfile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lockfile.Close()
+	assert.Equal(t, lockfile.IsNewlyCreated(), false)
+	assert.Equal(t, lockfile.Scaffolds["example"].Source, "example")
+	if err := lockfile.Remove(); err == nil {
+		t.Error("expected an error removing a read-only lockfile")
+	}
+}
+
+func TestLockfileChanged(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), config.LockfileFilename)
+	lockfile, err := config.LoadLockfile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lockfile.Close()
+
+	// Simulate another process that doesn't respect the lock
+	changed := "[scaffolds]\n[scaffolds.other]\nsource = \"other\"\n"
+	if err := os.WriteFile(filename, []byte(changed), 0644); err != nil {
+		t.Fatal(err)
+	}
+	lockfile.Scaffolds["example"] = config.NewLockfileScaffold("example")
+	if err := lockfile.WriteUpdated(); !errors.Is(err, config.ErrLockfileChanged) {
+		t.Fatalf("expected ErrLockfileChanged, got %v", err)
+	}
+	if err := lockfile.Remove(); !errors.Is(err, config.ErrLockfileChanged) {
+		t.Fatalf("expected ErrLockfileChanged, got %v", err)
+	}
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, string(data), changed)
+}
+
+func TestLockfileLock(t *testing.T) {
+	switch runtime.GOOS {
+	case "darwin", "dragonfly", "freebsd", "linux", "netbsd", "openbsd":
+	default:
+		t.Skip("advisory locks are not supported on " + runtime.GOOS)
+	}
+	filename := filepath.Join(t.TempDir(), config.LockfileFilename)
+	first, err := config.LoadLockfile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	loaded := make(chan *config.Lockfile)
+	go func() {
+		second, err := config.LoadLockfile(filename)
+		if err != nil {
+			t.Error(err)
+		}
+		loaded <- second
+	}()
+	select {
+	case <-loaded:
+		t.Fatal("lockfile was loaded while another lockfile held the lock")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	first.Scaffolds["example"] = config.NewLockfileScaffold("example")
+	if err := first.WriteUpdated(); err != nil {
+		t.Fatal(err)
+	}
+	first.Close()
+	second := <-loaded
+	defer second.Close()
+	// The second lockfile sees the changes made while it was waiting
+	assert.Equal(t, second.Scaffolds["example"].Source, "example")
+}
+
+func TestLockfileSharedLock(t *testing.T) {
+	switch runtime.GOOS {
+	case "darwin", "dragonfly", "freebsd", "linux", "netbsd", "openbsd":
+	default:
+		t.Skip("advisory locks are not supported on " + runtime.GOOS)
+	}
+	filename := filepath.Join(t.TempDir(), config.LockfileFilename)
+	created, err := config.LoadLockfile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	created.Close()
+
+	// Read-only lockfiles don't wait for each other
+	first, err := config.ReadLockfile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	read := make(chan *config.Lockfile)
+	go func() {
+		second, err := config.ReadLockfile(filename)
+		if err != nil {
+			t.Error(err)
+		}
+		read <- second
+	}()
+	select {
+	case second := <-read:
+		second.Close()
+	case <-time.After(time.Second):
+		t.Fatal("read-only lockfile waited for another read-only lockfile")
+	}
+
+	// But a lockfile that can be written waits for them
+	loaded := make(chan *config.Lockfile)
+	go func() {
+		writable, err := config.LoadLockfile(filename)
+		if err != nil {
+			t.Error(err)
+		}
+		loaded <- writable
+	}()
+	select {
+	case <-loaded:
+		t.Fatal("lockfile was loaded while a read-only lockfile held the lock")
+	case <-time.After(100 * time.Millisecond):
+	}
+	first.Close()
+	writable := <-loaded
+	writable.Close()
+}
+
+func TestLockfileVersion(t *testing.T) {
+	dir := t.TempDir()
+	filename := filepath.Join(dir, config.LockfileFilename)
+
+	// Lockfiles written before lockfile_version was added are upgraded
+	unversioned := `[scaffolds]
+[scaffolds.example]
+source = "example"
+
+[[scaffolds.example.file]]
+path = "go.mod"
+checksum = "abc"
+[scaffolds.example.vars]
+name = "foo"
+`
+	if err := os.WriteFile(filename, []byte(unversioned), 0644); err != nil {
+		t.Fatal(err)
+	}
+	lockfile, err := config.LoadLockfile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, lockfile.Version, config.LockfileVersion)
+	assert.Equal(t, lockfile.Scaffolds["example"].Files[0].Checksum, "abc")
+	assert.Equal(t, lockfile.Scaffolds["example"].Vars["name"], "foo")
+	data, err := lockfile.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.StrContains(t, string(data), "lockfile_version = 1")
+	lockfile.Close()
+
+	// Lockfiles from newer versions of rescaffold are rejected, even if they
+	// contain keys this version doesn't know about
+	newer := "lockfile_version = 99\n[scaffolds]\n[scaffolds.example]\nsource = \"example\"\ncreated = 2026-01-01\n"
+	if err := os.WriteFile(filename, []byte(newer), 0644); err != nil {
+		t.Fatal(err)
+	}
+	_, err = config.LoadLockfile(filename)
+	if err == nil {
+		t.Fatal("expected an error loading a newer lockfile")
+	}
+	assert.StrContains(t, err.Error(), "upgrade rescaffold")
+}
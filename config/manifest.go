@@ -3,7 +3,13 @@ package config
 import (
 	"fmt"
 	"io"
+	"net/mail"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
 	"strings"
+	"unicode/utf8"
 
 	"github.com/BurntSushi/toml"
 )
@@ -13,11 +19,27 @@ const (
 )
 
 type Manifest struct {
+	// RescaffoldVersion is the range of rescaffold versions that can generate
+	// the scaffold, e.g. ">=0.4, <1"
+	RescaffoldVersion string `toml:"rescaffold_version,omitempty"`
+
 	Meta *ManifestMeta `toml:"meta"`
 
 	Config *ManifestConfig `toml:"config"`
 
 	Vars map[string]*ManifestVar `toml:"vars"`
+
+	Files []*ManifestFile `toml:"files"`
+}
+
+// ManifestFile sets options for the scaffold files matching a glob pattern.
+type ManifestFile struct {
+	// Path is a glob pattern, relative to the scaffold root, matching files or
+	// directories in the scaffold. "**" matches any number of directories.
+	Path string `toml:"path"`
+	// When is an expression that determines whether the matching files are
+	// generated, e.g. `use_docker == true`
+	When string `toml:"when"`
 }
 
 type ManifestMeta struct {
@@ -27,33 +49,321 @@ type ManifestMeta struct {
 	PostInstall string `toml:"post_install"`
 }
 
+const (
+	VarTypeString = "string"
+	VarTypeEnum   = "enum"
+	VarTypeBool   = "bool"
+	VarTypeInt    = "int"
+	VarTypePort   = "port"
+	VarTypePath   = "path"
+	VarTypeEmail  = "email"
+	VarTypeSemver = "semver"
+	VarTypeList   = "list"
+)
+
+// ListSeparator separates the items of list var values.
+const ListSeparator = ","
+
 type ManifestVar struct {
 	Type        string   `toml:"type"`
 	Description string   `toml:"description"`
 	EnumValues  []string `toml:"enum_values"`
 	Default     string   `toml:"default"`
+
+	// Min and Max are the inclusive bounds of int vars
+	Min *int `toml:"min"`
+	Max *int `toml:"max"`
+	// Pattern is a regular expression that string vars must match in full
+	Pattern string `toml:"pattern"`
+	// pattern is Pattern compiled, set when the manifest is parsed
+	pattern *regexp.Regexp
+	// MinLength and MaxLength bound the number of characters in string vars. A
+	// MaxLength of 0 means there is no maximum.
+	MinLength int `toml:"min_length"`
+	MaxLength int `toml:"max_length"`
+
+	// Expr and Template make a var derived: instead of being set by the user,
+	// its value is computed from other vars, either by evaluating an expression
+	// or by applying template replacement to a string
+	Expr     string `toml:"expr"`
+	Template string `toml:"template"`
+
+	// Item is the name bound to each item of a list var when a scaffold file's
+	// path refers to it, which generates the file once per item
+	Item string `toml:"item"`
+}
+
+// IsDerived reports whether the var's value is computed from other vars.
+func (v *ManifestVar) IsDerived() bool {
+	return v.Expr != "" || v.Template != ""
 }
 
 type ManifestConfig struct {
 	OpenDelim     string `toml:"open_delim"`
 	CloseDelim    string `toml:"close_delim"`
 	ModifierDelim string `toml:"modifier_delim"`
+	// Directives enables if and for blocks in file contents. They're off by
+	// default, so that files containing other template languages, whose lines
+	// may look like directives, are rendered unchanged.
+	Directives bool `toml:"directives"`
+	// Binary is a list of glob patterns matching scaffold files that are copied
+	// verbatim, without template replacement. Files containing NUL bytes are
+	// always copied verbatim.
+	Binary []string `toml:"binary"`
+	// Exclude is a list of patterns, with the same semantics as a
+	// .rescaffoldignore file, matching files in the scaffold directory that are
+	// not part of the scaffold
+	Exclude []string `toml:"exclude"`
 }
 
 func ParseManifest(data io.Reader) (*Manifest, error) {
-	manifest := &Manifest{}
-	meta, err := toml.NewDecoder(data).Decode(manifest)
+	raw, err := io.ReadAll(data)
 	if err != nil {
 		return nil, err
 	}
 
+	// Check the version before anything else, since manifests for newer
+	// versions of rescaffold may contain keys this version doesn't know about,
+	// or known keys with different types
+	versioned := struct {
+		RescaffoldVersion string `toml:"rescaffold_version"`
+	}{}
+	if _, err := toml.Decode(string(raw), &versioned); err == nil && versioned.RescaffoldVersion != "" {
+		if err := checkVersion(versioned.RescaffoldVersion); err != nil {
+			return nil, err
+		}
+	}
+
+	manifest := &Manifest{}
+	meta, err := toml.Decode(string(raw), manifest)
+	if err != nil {
+		return nil, err
+	}
 	undecodedKeys := meta.Undecoded()
 	if len(undecodedKeys) > 0 {
 		return nil, fmt.Errorf("unknown keys in manifest: %v", undecodedKeys)
 	}
+	varNames := make([]string, 0, len(manifest.Vars))
+	for varName := range manifest.Vars {
+		varNames = append(varNames, varName)
+	}
+	sort.Strings(varNames)
+	for _, varName := range varNames {
+		v := manifest.Vars[varName]
+		if v.Pattern != "" {
+			pattern, err := compilePattern(v.Pattern)
+			if err != nil {
+				return nil, &VarError{Var: varName, Key: "pattern", Err: fmt.Errorf("invalid pattern for var %s: %w", varName, err)}
+			}
+			v.pattern = pattern
+		}
+		if v.Item != "" {
+			if v.Type != VarTypeList {
+				return nil, &VarError{Var: varName, Key: "item", Err: fmt.Errorf("var %s has an item name, but is not a list", varName)}
+			}
+			if _, ok := manifest.Vars[v.Item]; ok {
+				return nil, &VarError{Var: varName, Key: "item", Err: fmt.Errorf("item name %s of var %s is already the name of a var", v.Item, varName)}
+			}
+		}
+		// Defaults are checked now, rather than when they're first used, so that
+		// scaffold authors find out about invalid ones right away
+		if v.Default != "" && !v.IsDerived() {
+			if err := v.Validate(v.Default); err != nil {
+				return nil, &VarError{Var: varName, Key: "default", Err: fmt.Errorf("default of var %s is invalid: %w", varName, err)}
+			}
+		}
+	}
 	return manifest, nil
 }
 
+// VarError is an error in the declaration of a var in a manifest.
+type VarError struct {
+	Var string
+	// Key is the key of the var's table that the error is in, e.g. "default"
+	Key string
+	Err error
+}
+
+func (e *VarError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *VarError) Unwrap() error {
+	return e.Err
+}
+
+// SplitList returns the items of a list var value.
+func SplitList(value string) []string {
+	items := []string{}
+	for _, item := range strings.Split(value, ListSeparator) {
+		item = strings.TrimSpace(item)
+		if item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// semverRegexp matches semantic versions, as defined by https://semver.org, with
+// an optional leading "v"
+var semverRegexp = regexp.MustCompile(`^v?(0|[1-9]\d*)\.(0|[1-9]\d*)\.(0|[1-9]\d*)` +
+	`(?:-((?:0|[1-9]\d*|\d*[a-zA-Z-][0-9a-zA-Z-]*)(?:\.(?:0|[1-9]\d*|\d*[a-zA-Z-][0-9a-zA-Z-]*))*))?` +
+	`(?:\+([0-9a-zA-Z-]+(?:\.[0-9a-zA-Z-]+)*))?$`)
+
+// Validate checks that value is valid for the var's type.
+func (v *ManifestVar) Validate(value string) error {
+	_, err := v.Parse(value)
+	return err
+}
+
+// Parse checks that value is valid for the var's type, and returns it in
+// canonical form: bools are "true" or "false", numbers have no sign or leading
+// zeros unless needed, paths are cleaned, and list items are trimmed and
+// separated by ListSeparator.
+func (v *ManifestVar) Parse(value string) (string, error) {
+	switch v.Type {
+	case VarTypeString, "":
+		if err := v.validateString(value); err != nil {
+			return "", err
+		}
+		return value, nil
+	case VarTypeEnum:
+		for _, enumValue := range v.EnumValues {
+			if value == enumValue {
+				return value, nil
+			}
+		}
+		return "", fmt.Errorf("%q is not one of %s", value, strings.Join(v.EnumValues, ", "))
+	case VarTypeBool:
+		switch strings.ToLower(value) {
+		case "true", "t", "yes", "y", "1":
+			return "true", nil
+		case "false", "f", "no", "n", "0":
+			return "false", nil
+		}
+		return "", fmt.Errorf("%q is not a boolean, must be true or false", value)
+	case VarTypeInt:
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return "", fmt.Errorf("%q is not an integer", value)
+		}
+		if v.Min != nil && n < *v.Min {
+			return "", fmt.Errorf("%d is less than the minimum of %d", n, *v.Min)
+		}
+		if v.Max != nil && n > *v.Max {
+			return "", fmt.Errorf("%d is greater than the maximum of %d", n, *v.Max)
+		}
+		return strconv.Itoa(n), nil
+	case VarTypePort:
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 || n > 65535 {
+			return "", fmt.Errorf("%q is not a port number between 1 and 65535", value)
+		}
+		return strconv.Itoa(n), nil
+	case VarTypePath:
+		if value == "" || strings.ContainsRune(value, 0) {
+			return "", fmt.Errorf("%q is not a valid path", value)
+		}
+		cleaned := path.Clean(value)
+		if path.IsAbs(cleaned) || cleaned == ".." || strings.HasPrefix(cleaned, "../") {
+			return "", fmt.Errorf("%q must be a relative path within the project", value)
+		}
+		return cleaned, nil
+	case VarTypeEmail:
+		addr, err := mail.ParseAddress(value)
+		if err != nil || addr.Address != value {
+			return "", fmt.Errorf("%q is not an email address", value)
+		}
+		return value, nil
+	case VarTypeSemver:
+		if !semverRegexp.MatchString(value) {
+			return "", fmt.Errorf("%q is not a semantic version (e.g. 1.2.3)", value)
+		}
+		return value, nil
+	case VarTypeList:
+		items := SplitList(value)
+		seen := make(map[string]bool, len(items))
+		for _, item := range items {
+			if err := v.validateString(item); err != nil {
+				return "", err
+			}
+			if seen[item] {
+				return "", fmt.Errorf("%q is in the list more than once", item)
+			}
+			seen[item] = true
+		}
+		return strings.Join(items, ListSeparator), nil
+	default:
+		return "", fmt.Errorf("unknown var type %q", v.Type)
+	}
+}
+
+func (v *ManifestVar) validateString(value string) error {
+	length := utf8.RuneCountInString(value)
+	if length < v.MinLength {
+		return fmt.Errorf("%q is shorter than the minimum length of %d", value, v.MinLength)
+	}
+	if v.MaxLength > 0 && length > v.MaxLength {
+		return fmt.Errorf("%q is longer than the maximum length of %d", value, v.MaxLength)
+	}
+	if v.Pattern != "" {
+		pattern := v.pattern
+		if pattern == nil {
+			// Vars that weren't parsed from a manifest haven't been compiled yet
+			var err error
+			pattern, err = compilePattern(v.Pattern)
+			if err != nil {
+				return fmt.Errorf("invalid pattern in manifest: %w", err)
+			}
+			v.pattern = pattern
+		}
+		if !pattern.MatchString(value) {
+			return fmt.Errorf("%q does not match the pattern %s", value, v.Pattern)
+		}
+	}
+	return nil
+}
+
+// compilePattern compiles the pattern of a string var, which must match values
+// in full.
+func compilePattern(pattern string) (*regexp.Regexp, error) {
+	return regexp.Compile(`^(?:` + pattern + `)$`)
+}
+
+// TypeHint returns a short description of the values the var accepts, or an
+// empty string if any value is accepted.
+func (v *ManifestVar) TypeHint() string {
+	switch v.Type {
+	case VarTypeBool:
+		return "true/false"
+	case VarTypeInt:
+		switch {
+		case v.Min != nil && v.Max != nil:
+			return fmt.Sprintf("integer, %d to %d", *v.Min, *v.Max)
+		case v.Min != nil:
+			return fmt.Sprintf("integer, at least %d", *v.Min)
+		case v.Max != nil:
+			return fmt.Sprintf("integer, at most %d", *v.Max)
+		}
+		return "integer"
+	case VarTypePort:
+		return "port, 1 to 65535"
+	case VarTypePath:
+		return "relative path"
+	case VarTypeEmail:
+		return "email address"
+	case VarTypeSemver:
+		return "semantic version"
+	case VarTypeList:
+		return "comma-separated list"
+	case VarTypeString, "":
+		if v.Pattern != "" {
+			return "matching " + v.Pattern
+		}
+	}
+	return ""
+}
+
 func (m *Manifest) String() string {
 	buf := &strings.Builder{}
 	if err := toml.NewEncoder(buf).Encode(m); err != nil {
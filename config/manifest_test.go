@@ -21,6 +21,10 @@ close_delim = "_"
 [vars.project_name]
 type = "string"
 description = "A short, descriptive name for your project"
+
+[[files]]
+path = "docker/**"
+when = "use_docker == true"
 `
 	manifest, err := config.ParseManifest(bytes.NewBuffer([]byte(data)))
 	if err != nil {
@@ -29,6 +33,8 @@ description = "A short, descriptive name for your project"
 	assert.Equal(t, manifest.Meta.Title, "Example Scaffold")
 	assert.Equal(t, manifest.Config.OpenDelim, "_")
 	assert.Equal(t, manifest.Vars["project_name"].Type, "string")
+	assert.Equal(t, len(manifest.Files), 1)
+	assert.Equal(t, manifest.Files[0].When, "use_docker == true")
 	assert.StrNotContains(t, manifest.String(), "unencodable")
 }
 
@@ -52,3 +58,110 @@ author_email = "me@example.com"
 	}
 	t.Log(err)
 }
+
+func TestValidateEnum(t *testing.T) {
+	v := &config.ManifestVar{
+		Type:       config.VarTypeEnum,
+		EnumValues: []string{"12", "13", "14"},
+	}
+	assert.Equal(t, v.Validate("13"), nil)
+	if err := v.Validate("15"); err == nil {
+		t.Error("expected error")
+	}
+	if err := (&config.ManifestVar{Type: "bogus"}).Validate("x"); err == nil {
+		t.Error("expected error")
+	}
+}
+
+func TestParseVarTypes(t *testing.T) {
+	min, max := 1, 10
+	cases := []struct {
+		v        *config.ManifestVar
+		value    string
+		expected string
+		valid    bool
+	}{
+		{&config.ManifestVar{Type: config.VarTypeBool}, "yes", "true", true},
+		{&config.ManifestVar{Type: config.VarTypeBool}, "F", "false", true},
+		{&config.ManifestVar{Type: config.VarTypeBool}, "maybe", "", false},
+		{&config.ManifestVar{Type: config.VarTypeInt, Min: &min, Max: &max}, "007", "7", true},
+		{&config.ManifestVar{Type: config.VarTypeInt, Min: &min, Max: &max}, "11", "", false},
+		{&config.ManifestVar{Type: config.VarTypeInt}, "eight", "", false},
+		{&config.ManifestVar{Type: config.VarTypePort}, "8080", "8080", true},
+		{&config.ManifestVar{Type: config.VarTypePort}, "eight thousand", "", false},
+		{&config.ManifestVar{Type: config.VarTypePort}, "70000", "", false},
+		{&config.ManifestVar{Type: config.VarTypePath}, "cmd//server/", "cmd/server", true},
+		{&config.ManifestVar{Type: config.VarTypePath}, "../outside", "", false},
+		{&config.ManifestVar{Type: config.VarTypePath}, "/etc", "", false},
+		{&config.ManifestVar{Type: config.VarTypeEmail}, "me@example.com", "me@example.com", true},
+		{&config.ManifestVar{Type: config.VarTypeEmail}, "Me <me@example.com>", "", false},
+		{&config.ManifestVar{Type: config.VarTypeSemver}, "v1.2.3-rc.1", "v1.2.3-rc.1", true},
+		{&config.ManifestVar{Type: config.VarTypeSemver}, "1.2", "", false},
+		{&config.ManifestVar{Type: config.VarTypeString, Pattern: "[a-z]+"}, "abc", "abc", true},
+		{&config.ManifestVar{Type: config.VarTypeString, Pattern: "[a-z]+"}, "abc1", "", false},
+		{&config.ManifestVar{Type: config.VarTypeString, MinLength: 2, MaxLength: 3}, "a", "", false},
+		{&config.ManifestVar{Type: config.VarTypeString, MinLength: 2, MaxLength: 3}, "abcd", "", false},
+		{&config.ManifestVar{Type: config.VarTypeString, MinLength: 2, MaxLength: 3}, "abc", "abc", true},
+		{&config.ManifestVar{Type: config.VarTypeList}, " api, worker,,", "api,worker", true},
+		{&config.ManifestVar{Type: config.VarTypeList}, "api,api", "", false},
+		{&config.ManifestVar{Type: config.VarTypeList, Pattern: "[a-z]+"}, "api,Worker", "", false},
+	}
+	for _, c := range cases {
+		parsed, err := c.v.Parse(c.value)
+		if c.valid && err != nil {
+			t.Errorf("expected %q to be a valid %s: %v", c.value, c.v.Type, err)
+		}
+		if !c.valid && err == nil {
+			t.Errorf("expected %q to be an invalid %s", c.value, c.v.Type)
+		}
+		assert.Equal(t, parsed, c.expected)
+	}
+}
+
+func TestParseManifestDefaults(t *testing.T) {
+	tests := []struct {
+		vars string
+		err  string
+	}{
+		{"[vars.db]\ntype = \"enum\"\nenum_values = [\"postgres\", \"sqlite\"]\ndefault = \"sqlite\"\n", ""},
+		{"[vars.db]\ntype = \"enum\"\nenum_values = [\"postgres\", \"sqlite\"]\ndefault = \"mysql\"\n", "default of var db is invalid"},
+		{"[vars.port]\ntype = \"port\"\ndefault = \"http\"\n", "default of var port is invalid"},
+		// Derived vars have no defaults of their own to check
+		{"[vars.name]\n[vars.upper]\nexpr = \"name|uppercase\"\n", ""},
+	}
+	for _, test := range tests {
+		_, err := config.ParseManifest(bytes.NewBufferString("[meta]\ntitle = \"Test\"\n" + test.vars))
+		if test.err == "" {
+			if err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+			continue
+		}
+		if err == nil {
+			t.Errorf("expected an error parsing %q", test.vars)
+			continue
+		}
+		assert.StrContains(t, err.Error(), test.err)
+	}
+}
+
+func TestParseManifestPattern(t *testing.T) {
+	data := "[meta]\ntitle = \"Test\"\n[vars.name]\npattern = \"[a-z]+\"\n"
+	manifest, err := config.ParseManifest(bytes.NewBufferString(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, manifest.Vars["name"].Validate("app"), nil)
+	if err := manifest.Vars["name"].Validate("App1"); err == nil {
+		t.Error("expected a value not matching the pattern to be invalid")
+	}
+
+	// Invalid patterns are rejected when the manifest is parsed, rather than
+	// whenever a value is validated
+	data = "[meta]\ntitle = \"Test\"\n[vars.name]\npattern = \"[a-z\"\n"
+	_, err = config.ParseManifest(bytes.NewBufferString(data))
+	if err == nil {
+		t.Fatal("expected an error for an invalid pattern")
+	}
+	assert.StrContains(t, err.Error(), "invalid pattern for var name")
+}
@@ -0,0 +1,58 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/BurntSushi/toml"
+)
+
+// lockfileMigration upgrades a decoded lockfile by one version, modifying it in
+// place. Migrations work on the generic form of the lockfile, so that they can
+// handle keys that the current Lockfile struct no longer has.
+type lockfileMigration func(lockfile map[string]any) error
+
+// lockfileMigrations upgrade lockfiles written by older versions of rescaffold.
+// lockfileMigrations[n] upgrades a lockfile from version n to version n+1, so
+// there is one for every version before LockfileVersion.
+var lockfileMigrations = []lockfileMigration{
+	// Lockfiles written before lockfile_version was added are version 0, and
+	// otherwise have the same layout as version 1
+	0: func(lockfile map[string]any) error { return nil },
+}
+
+// migrateLockfile upgrades the encoded lockfile in data to LockfileVersion,
+// returning it unchanged if it's already current. Lockfiles from newer versions
+// of rescaffold can't be read, since they may contain anything.
+func migrateLockfile(data []byte) ([]byte, error) {
+	lockfile := map[string]any{}
+	if _, err := toml.NewDecoder(bytes.NewReader(data)).Decode(&lockfile); err != nil {
+		return nil, err
+	}
+	version := 0
+	if value, ok := lockfile["lockfile_version"]; ok {
+		v, ok := value.(int64)
+		if !ok || v < 0 {
+			return nil, fmt.Errorf("invalid lockfile_version %v", value)
+		}
+		if v > LockfileVersion {
+			return nil, fmt.Errorf("lockfile version %d is newer than this version of rescaffold supports (%d), upgrade rescaffold to use it", v, LockfileVersion)
+		}
+		version = int(v)
+	}
+	if version == LockfileVersion {
+		return data, nil
+	}
+
+	for ; version < LockfileVersion; version++ {
+		if err := lockfileMigrations[version](lockfile); err != nil {
+			return nil, fmt.Errorf("could not upgrade lockfile from version %d: %w", version, err)
+		}
+	}
+	lockfile["lockfile_version"] = int64(LockfileVersion)
+	buf := &bytes.Buffer{}
+	if err := toml.NewEncoder(buf).Encode(lockfile); err != nil {
+		return nil, fmt.Errorf("could not upgrade lockfile: %w", err)
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,89 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// LoadValuesFile loads var values from a TOML, JSON, or YAML file, depending on
+// the file's extension. The file must contain a single table or object mapping
+// var names to values.
+func LoadValuesFile(filename string) (map[string]string, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("could not read values file: %w", err)
+	}
+
+	raw := map[string]any{}
+	switch ext := strings.ToLower(path.Ext(filename)); ext {
+	case ".toml":
+		err = toml.Unmarshal(data, &raw)
+	case ".json":
+		// Numbers are kept as written, rather than as float64, which would turn
+		// large integers into exponent form
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.UseNumber()
+		err = dec.Decode(&raw)
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &raw)
+	default:
+		return nil, fmt.Errorf("unsupported values file type %q, must be .toml, .json, or .yaml", ext)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not parse values file %s: %w", filename, err)
+	}
+
+	values := make(map[string]string, len(raw))
+	for name, value := range raw {
+		switch value := value.(type) {
+		case string:
+			values[name] = value
+		case bool, int, int64, float64, json.Number:
+			values[name] = formatValue(value)
+		case []any:
+			// Arrays are values of list vars
+			items := make([]string, 0, len(value))
+			for _, item := range value {
+				switch item := item.(type) {
+				case string, bool, int, int64, float64, json.Number:
+					itemStr := formatValue(item)
+					if strings.Contains(itemStr, ListSeparator) {
+						return nil, fmt.Errorf("items of %s in %s must not contain %q", name, filename, ListSeparator)
+					}
+					items = append(items, itemStr)
+				default:
+					return nil, fmt.Errorf("items of %s in %s must be strings, numbers, or booleans", name, filename)
+				}
+			}
+			values[name] = strings.Join(items, ListSeparator)
+		default:
+			return nil, fmt.Errorf("value of %s in %s must be a string, number, boolean, or array", name, filename)
+		}
+	}
+	return values, nil
+}
+
+// formatValue returns a scalar value from a values file as a string. Floats are
+// formatted without an exponent, so that whole numbers remain valid ints.
+func formatValue(value any) string {
+	switch value := value.(type) {
+	case float64:
+		return strconv.FormatFloat(value, 'f', -1, 64)
+	case json.Number:
+		if _, err := value.Int64(); err != nil {
+			if f, err := value.Float64(); err == nil {
+				return strconv.FormatFloat(f, 'f', -1, 64)
+			}
+		}
+		return value.String()
+	}
+	return fmt.Sprint(value)
+}
@@ -0,0 +1,68 @@
+package config_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/olafal0/rescaffold/assert"
+	"github.com/olafal0/rescaffold/config"
+)
+
+func TestLoadValuesFile(t *testing.T) {
+	tests := []struct {
+		filename string
+		content  string
+		expected map[string]string
+		err      string
+	}{
+		{
+			filename: "vars.toml",
+			content:  "name = \"app\"\nport = 8080\ndocker = true\nratio = 0.5\nservices = [\"api\", \"worker\"]\n",
+			expected: map[string]string{"name": "app", "port": "8080", "docker": "true", "ratio": "0.5", "services": "api,worker"},
+		},
+		{
+			filename: "vars.json",
+			content:  `{"name": "app", "big": 12345678, "exp": 1e11, "docker": false, "ids": [1, 23456789]}`,
+			expected: map[string]string{"name": "app", "big": "12345678", "exp": "100000000000", "docker": "false", "ids": "1,23456789"},
+		},
+		{
+			filename: "vars.yaml",
+			content:  "name: app\nbig: 12345678\nratio: 1.5\nservices:\n  - api\n  - worker\n",
+			expected: map[string]string{"name": "app", "big": "12345678", "ratio": "1.5", "services": "api,worker"},
+		},
+		{
+			filename: "vars.yml",
+			content:  "name: app\n",
+			expected: map[string]string{"name": "app"},
+		},
+		{filename: "vars.txt", content: "name = app", err: "unsupported values file type"},
+		{filename: "vars.toml", content: "name = ", err: "could not parse values file"},
+		{filename: "vars.toml", content: "[db]\nname = \"x\"\n", err: "value of db"},
+		{filename: "vars.json", content: `{"services": [{"name": "api"}]}`, err: "items of services"},
+		{filename: "vars.json", content: `{"services": ["a,b"]}`, err: "must not contain"},
+	}
+	for _, test := range tests {
+		filename := filepath.Join(t.TempDir(), test.filename)
+		if err := os.WriteFile(filename, []byte(test.content), 0666); err != nil {
+			t.Fatal(err)
+		}
+		values, err := config.LoadValuesFile(filename)
+		if test.err != "" {
+			if err == nil {
+				t.Errorf("%s: expected an error loading %q", test.filename, test.content)
+				continue
+			}
+			assert.StrContains(t, err.Error(), test.err)
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", test.filename, err)
+			continue
+		}
+		assert.Equal(t, len(values), len(test.expected))
+		for name, value := range test.expected {
+			assert.Equal(t, values[name], value)
+		}
+	}
+}
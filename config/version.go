@@ -0,0 +1,246 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Version is the version of rescaffold, which scaffolds can require a range of
+// with rescaffold_version. Release builds set it with
+// -ldflags "-X github.com/olafal0/rescaffold/config.Version=x.y.z".
+var Version = "0.5.0"
+
+// version is a parsed semantic version. Versions in ranges may be partial, e.g.
+// "1" or "1.2", in which case parts records how many numbers were given.
+type version struct {
+	nums       [3]int
+	prerelease string
+	parts      int
+}
+
+// parseVersion parses a full or partial semantic version, with an optional
+// leading "v". Build metadata is ignored.
+func parseVersion(s string) (version, error) {
+	v := version{}
+	rest := strings.TrimPrefix(s, "v")
+	rest, _, _ = strings.Cut(rest, "+")
+	rest, v.prerelease, _ = strings.Cut(rest, "-")
+	nums := strings.Split(rest, ".")
+	if len(nums) > 3 || (v.prerelease != "" && len(nums) != 3) {
+		return v, fmt.Errorf("%q is not a version", s)
+	}
+	for i, num := range nums {
+		n, err := strconv.Atoi(num)
+		if err != nil || n < 0 || (len(num) > 1 && num[0] == '0') {
+			return v, fmt.Errorf("%q is not a version", s)
+		}
+		v.nums[i] = n
+	}
+	v.parts = len(nums)
+	return v, nil
+}
+
+func (v version) String() string {
+	s := fmt.Sprintf("%d.%d.%d", v.nums[0], v.nums[1], v.nums[2])
+	if v.prerelease != "" {
+		s += "-" + v.prerelease
+	}
+	return s
+}
+
+// next returns the lowest version after every version matching a partial
+// version, e.g. 1.3.0 for 1.2. Full versions are returned unchanged.
+func (v version) next() version {
+	if v.parts == 3 {
+		return v
+	}
+	next := version{parts: 3}
+	copy(next.nums[:], v.nums[:v.parts])
+	next.nums[v.parts-1]++
+	return next
+}
+
+// compareVersions returns -1, 0, or 1 if a is less than, equal to, or greater
+// than b, following semver precedence.
+func compareVersions(a, b version) int {
+	for i := range a.nums {
+		if a.nums[i] != b.nums[i] {
+			return compareInts(a.nums[i], b.nums[i])
+		}
+	}
+	// A version with a prerelease comes before the same version without one
+	switch {
+	case a.prerelease == b.prerelease:
+		return 0
+	case a.prerelease == "":
+		return 1
+	case b.prerelease == "":
+		return -1
+	}
+	aIDs, bIDs := strings.Split(a.prerelease, "."), strings.Split(b.prerelease, ".")
+	for i := 0; i < len(aIDs) && i < len(bIDs); i++ {
+		if aIDs[i] == bIDs[i] {
+			continue
+		}
+		aNum, aErr := strconv.Atoi(aIDs[i])
+		bNum, bErr := strconv.Atoi(bIDs[i])
+		switch {
+		case aErr == nil && bErr == nil:
+			return compareInts(aNum, bNum)
+		case aErr == nil:
+			// Numeric identifiers come before alphanumeric ones
+			return -1
+		case bErr == nil:
+			return 1
+		case aIDs[i] < bIDs[i]:
+			return -1
+		default:
+			return 1
+		}
+	}
+	return compareInts(len(aIDs), len(bIDs))
+}
+
+func compareInts(a, b int) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// versionOperators are the comparison operators allowed in version ranges.
+// Longer operators come first, so that they are matched before their prefixes.
+var versionOperators = []string{">=", "<=", "!=", ">", "<", "="}
+
+// versionConstraint is a single comparison in a version range, e.g. ">=0.4".
+type versionConstraint struct {
+	op      string
+	version version
+}
+
+// matches reports whether v satisfies the constraint. A partial version stands
+// for every version that starts with it, so "<=1" matches 1.9.0 and ">1"
+// doesn't.
+func (c versionConstraint) matches(v version) bool {
+	lower, upper := c.version, c.version.next()
+	// Full versions are an exact match, which is the range [lower, upper]
+	inclusive := c.version.parts == 3
+	belowUpper := compareVersions(v, upper) < 0 || (inclusive && compareVersions(v, upper) == 0)
+	switch c.op {
+	case ">=":
+		return compareVersions(v, lower) >= 0
+	case ">":
+		return !belowUpper
+	case "<":
+		return compareVersions(v, lower) < 0
+	case "<=":
+		return belowUpper
+	case "!=":
+		return compareVersions(v, lower) < 0 || !belowUpper
+	default:
+		return compareVersions(v, lower) >= 0 && belowUpper
+	}
+}
+
+// VersionRange is a set of constraints on the version of rescaffold, such as
+// ">=0.4, <1", all of which must be satisfied. A version without an operator
+// matches every version that starts with it, so "0" matches 0.x.y.
+type VersionRange struct {
+	constraints []versionConstraint
+	text        string
+}
+
+// ParseVersionRange parses a comma-separated list of version constraints, each
+// of which is a full or partial version, optionally preceded by one of the
+// operators =, !=, <, <=, >, or >=.
+func ParseVersionRange(s string) (*VersionRange, error) {
+	r := &VersionRange{text: s}
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		op := "="
+		for _, candidate := range versionOperators {
+			if strings.HasPrefix(part, candidate) {
+				op = candidate
+				part = strings.TrimSpace(strings.TrimPrefix(part, candidate))
+				break
+			}
+		}
+		v, err := parseVersion(part)
+		if err != nil {
+			return nil, fmt.Errorf("invalid version range %q: %w", s, err)
+		}
+		r.constraints = append(r.constraints, versionConstraint{op: op, version: v})
+	}
+	return r, nil
+}
+
+func (r *VersionRange) String() string {
+	return r.text
+}
+
+// Contains reports whether version satisfies every constraint in the range.
+func (r *VersionRange) Contains(version string) (bool, error) {
+	v, err := parseVersion(version)
+	if err != nil {
+		return false, err
+	}
+	if v.parts != 3 {
+		return false, fmt.Errorf("%q is not a full version", version)
+	}
+	_, ok := r.firstUnmatched(v)
+	return ok, nil
+}
+
+// firstUnmatched returns the first constraint that v doesn't satisfy, or false
+// if v is in the range.
+func (r *VersionRange) firstUnmatched(v version) (versionConstraint, bool) {
+	for _, c := range r.constraints {
+		if !c.matches(v) {
+			return c, false
+		}
+	}
+	return versionConstraint{}, true
+}
+
+// requiresNewer reports whether every version in the range is newer than v,
+// i.e. v fails a constraint because it's too old, rather than too new.
+func (r *VersionRange) requiresNewer(v version) bool {
+	c, ok := r.firstUnmatched(v)
+	if ok {
+		return false
+	}
+	switch c.op {
+	case ">=", ">":
+		return true
+	case "=":
+		return compareVersions(v, c.version) < 0
+	}
+	return false
+}
+
+// checkVersion returns an error if the running version of rescaffold is not in
+// the range a scaffold requires. Builds without a valid version, e.g. those
+// with a custom Version, are assumed to be compatible.
+func checkVersion(required string) error {
+	r, err := ParseVersionRange(required)
+	if err != nil {
+		return fmt.Errorf("invalid rescaffold_version: %w", err)
+	}
+	running, err := parseVersion(Version)
+	if err != nil || running.parts != 3 {
+		return nil
+	}
+	if _, ok := r.firstUnmatched(running); ok {
+		return nil
+	}
+	if r.requiresNewer(running) {
+		return fmt.Errorf("scaffold requires rescaffold %s, but this is rescaffold %s; "+
+			"upgrade with \"go install github.com/olafal0/rescaffold@latest\"", r, Version)
+	}
+	return fmt.Errorf("scaffold requires rescaffold %s, but this is rescaffold %s; "+
+		"use an older release of rescaffold, or ask the scaffold's author to update it", r, Version)
+}
@@ -0,0 +1,100 @@
+package config_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/olafal0/rescaffold/assert"
+	"github.com/olafal0/rescaffold/config"
+)
+
+func TestVersionRange(t *testing.T) {
+	tests := []struct {
+		versionRange string
+		version      string
+		contains     bool
+	}{
+		{"0", "0.5.0", true},
+		{"0", "1.0.0", false},
+		{"0.4", "0.4.9", true},
+		{"0.4", "0.5.0", false},
+		{">=0.4, <1", "0.4.0", true},
+		{">=0.4, <1", "0.12.3", true},
+		{">=0.4, <1", "0.3.9", false},
+		{">=0.4, <1", "1.0.0", false},
+		{">=0.4, <1", "1.0.0-rc.1", true},
+		{">0.4", "0.4.7", false},
+		{">0.4", "0.5.0", true},
+		{">0.4.1", "0.4.2", true},
+		{"<=1", "1.9.0", true},
+		{"<=1.2.3", "1.2.4", false},
+		{"=1.2.3", "v1.2.3", true},
+		{"!=1.2", "1.2.5", false},
+		{"!=1.2", "1.3.0", true},
+		{">=1.0.0-alpha.2", "1.0.0-alpha.10", true},
+		{">=1.0.0-beta", "1.0.0-alpha.10", false},
+	}
+	for _, test := range tests {
+		r, err := config.ParseVersionRange(test.versionRange)
+		if err != nil {
+			t.Errorf("%s: %v", test.versionRange, err)
+			continue
+		}
+		contains, err := r.Contains(test.version)
+		if err != nil {
+			t.Errorf("%s: %v", test.version, err)
+			continue
+		}
+		if contains != test.contains {
+			t.Errorf("expected %q contains %q to be %v", test.versionRange, test.version, test.contains)
+		}
+	}
+
+	for _, invalid := range []string{"", ">=", "1.x", "01.2", "~1", "1.2-rc.1", ">=0.4,"} {
+		if _, err := config.ParseVersionRange(invalid); err == nil {
+			t.Errorf("expected %q to be invalid", invalid)
+		}
+	}
+}
+
+func TestManifestVersion(t *testing.T) {
+	defer func(version string) { config.Version = version }(config.Version)
+	config.Version = "0.5.0"
+
+	parse := func(versionRange string) error {
+		data := "rescaffold_version = \"" + versionRange + "\"\n[meta]\ntitle = \"Example\"\n"
+		_, err := config.ParseManifest(strings.NewReader(data))
+		return err
+	}
+	if err := parse(">=0.4, <1"); err != nil {
+		t.Error(err)
+	}
+	err := parse(">=0.6")
+	if err == nil {
+		t.Fatal("expected an error for a scaffold requiring a newer version")
+	}
+	assert.StrContains(t, err.Error(), "requires rescaffold >=0.6, but this is rescaffold 0.5.0")
+	assert.StrContains(t, err.Error(), "go install")
+	err = parse("<0.5")
+	if err == nil {
+		t.Fatal("expected an error for a scaffold requiring an older version")
+	}
+	assert.StrContains(t, err.Error(), "older release")
+
+	// The version is checked before unknown keys, which newer versions of
+	// rescaffold may understand
+	data := "rescaffold_version = \"2\"\nnew_feature = true\n"
+	_, err = config.ParseManifest(strings.NewReader(data))
+	if err == nil {
+		t.Fatal("expected an error for a scaffold requiring a newer version")
+	}
+	assert.StrContains(t, err.Error(), "upgrade")
+
+	// Nor do known keys with a different type prevent the version check
+	data = "rescaffold_version = \"2\"\nmeta = \"Example\"\n"
+	_, err = config.ParseManifest(strings.NewReader(data))
+	if err == nil {
+		t.Fatal("expected an error for a scaffold requiring a newer version")
+	}
+	assert.StrContains(t, err.Error(), "upgrade")
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"sort"
+
+	"github.com/olafal0/rescaffold/scaffold"
+	"github.com/olafal0/rescaffold/set"
+)
+
+var diffCommand = &command{
+	name:    "diff",
+	args:    "[flags] [source[@ref]...]",
+	summary: "show how the latest scaffolds differ from the project",
+	description: `Diff renders the latest version of the given scaffolds, or all scaffolds in the
+project if none are given, with the vars recorded in .rescaffold.toml, and
+prints a unified diff from the files in the project to the rendered files.
+With -locked, the diff is from the files rendered from the commit recorded in
+.rescaffold.toml instead, showing only the upstream changes. Nothing is
+changed.`,
+	setup: setupDiff,
+}
+
+func setupDiff(flags *flag.FlagSet) func(args []string) error {
+	outputDir := flags.String("out", ".", "directory in which scaffold files are placed")
+	fromLocked := flags.Bool("locked", false, "diff from the files rendered from the locked commit, instead of the files in the project")
+	offline := flags.Bool("offline", false, "use cached revisions of git scaffolds instead of fetching them")
+	return func(args []string) error {
+		lockfile, err := openLockfile(*outputDir)
+		if err != nil {
+			return err
+		}
+		defer lockfile.Close()
+		if len(args) == 0 {
+			args = set.Keys(lockfile.Scaffolds)
+			sort.Strings(args)
+		}
+		for _, s := range args {
+			source, ref := scaffold.ParseSource(s)
+			err := scaffold.Diff(os.Stdout, lockfile, source, *outputDir, scaffold.DiffOptions{
+				Ref:        ref,
+				FromLocked: *fromLocked,
+				Offline:    *offline,
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
@@ -0,0 +1,135 @@
+// diff provides line-based diffing and three-way merging of text files.
+package diff
+
+import "bytes"
+
+// SplitLines splits data into lines, keeping line terminators attached to each
+// line so that joining the result reproduces data exactly.
+func SplitLines(data []byte) []string {
+	lines := []string{}
+	for len(data) > 0 {
+		i := bytes.IndexByte(data, '\n')
+		if i < 0 {
+			lines = append(lines, string(data))
+			break
+		}
+		lines = append(lines, string(data[:i+1]))
+		data = data[i+1:]
+	}
+	return lines
+}
+
+// JoinLines concatenates lines produced by SplitLines.
+func JoinLines(lines []string) []byte {
+	buf := &bytes.Buffer{}
+	for _, line := range lines {
+		buf.WriteString(line)
+	}
+	return buf.Bytes()
+}
+
+// Match is a pair of indices of equal lines in two sequences.
+type Match struct {
+	A, B int
+}
+
+// Matches returns the pairs of equal lines in a longest common subsequence of a
+// and b, in increasing order. It uses the linear space variant of the Myers
+// O(ND) algorithm, so memory use doesn't grow with the number of differences.
+func Matches(a, b []string) []Match {
+	matches := []Match{}
+	return appendMatches(matches, a, b, 0, 0)
+}
+
+// appendMatches appends the matches between a and b to matches, offsetting them
+// by aOff and bOff. The sequences are split at the middle snake of their edit
+// path, and each half is matched recursively.
+func appendMatches(matches []Match, a, b []string, aOff, bOff int) []Match {
+	// Strip common prefix and suffix, which are very common in practice and cheap
+	// to find.
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+
+	for i := 0; i < prefix; i++ {
+		matches = append(matches, Match{aOff + i, bOff + i})
+	}
+	midA, midB := a[prefix:len(a)-suffix], b[prefix:len(b)-suffix]
+	if len(midA) > 0 && len(midB) > 0 {
+		x, y, u, v := middleSnake(midA, midB)
+		matches = appendMatches(matches, midA[:x], midB[:y], aOff+prefix, bOff+prefix)
+		for i := 0; i < u-x; i++ {
+			matches = append(matches, Match{aOff + prefix + x + i, bOff + prefix + y + i})
+		}
+		matches = appendMatches(matches, midA[u:], midB[v:], aOff+prefix+u, bOff+prefix+v)
+	}
+	for i := suffix; i > 0; i-- {
+		matches = append(matches, Match{aOff + len(a) - i, bOff + len(b) - i})
+	}
+	return matches
+}
+
+// middleSnake finds the middle snake of a shortest edit path between a and b,
+// which must both be non-empty and differ in their first and last lines. The
+// snake runs diagonally from (x, y) to (u, v). It searches forward from the
+// start and backward from the end at the same time, until the searches
+// overlap, keeping only the furthest point reached on each diagonal.
+func middleSnake(a, b []string) (x, y, u, v int) {
+	n, m := len(a), len(b)
+	max := (n + m + 1) / 2
+	offset := max + 1
+	// forward[offset+k] is the furthest x reached on diagonal k = x-y from the
+	// start, and backward[offset+k] is the same from the end, with x and y
+	// measured from the end
+	forward := make([]int, 2*max+3)
+	backward := make([]int, 2*max+3)
+	delta := n - m
+	odd := delta%2 != 0
+
+	for d := 0; d <= max; d++ {
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && forward[offset+k-1] < forward[offset+k+1]) {
+				x = forward[offset+k+1]
+			} else {
+				x = forward[offset+k-1] + 1
+			}
+			y := x - k
+			startX, startY := x, y
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			forward[offset+k] = x
+			// The backward search has only taken d-1 steps so far
+			if c := delta - k; odd && c >= -(d-1) && c <= d-1 && x+backward[offset+c] >= n {
+				return startX, startY, x, y
+			}
+		}
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && backward[offset+k-1] < backward[offset+k+1]) {
+				x = backward[offset+k+1]
+			} else {
+				x = backward[offset+k-1] + 1
+			}
+			y := x - k
+			startX, startY := x, y
+			for x < n && y < m && a[n-1-x] == b[m-1-y] {
+				x++
+				y++
+			}
+			backward[offset+k] = x
+			if c := delta - k; !odd && c >= -d && c <= d && x+forward[offset+c] >= n {
+				return n - x, m - y, n - startX, m - startY
+			}
+		}
+	}
+	// The searches always overlap by the time half the edits have been made
+	panic("diff: no middle snake found")
+}
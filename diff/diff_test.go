@@ -0,0 +1,77 @@
+package diff_test
+
+import (
+	"math/rand"
+	"runtime"
+	"strconv"
+	"testing"
+
+	"github.com/olafal0/rescaffold/assert"
+	"github.com/olafal0/rescaffold/diff"
+)
+
+// lcsLength returns the length of the longest common subsequence of a and b,
+// by dynamic programming.
+func lcsLength(a, b []string) int {
+	lengths := make([][]int, len(a)+1)
+	for i := range lengths {
+		lengths[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			switch {
+			case a[i] == b[j]:
+				lengths[i][j] = lengths[i+1][j+1] + 1
+			case lengths[i+1][j] > lengths[i][j+1]:
+				lengths[i][j] = lengths[i+1][j]
+			default:
+				lengths[i][j] = lengths[i][j+1]
+			}
+		}
+	}
+	return lengths[0][0]
+}
+
+func TestMatchesLongest(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	randomLines := func() []string {
+		lines := make([]string, rng.Intn(30))
+		for i := range lines {
+			lines[i] = strconv.Itoa(rng.Intn(4)) + "\n"
+		}
+		return lines
+	}
+	for i := 0; i < 1000; i++ {
+		a, b := randomLines(), randomLines()
+		matches := diff.Matches(a, b)
+		for j, m := range matches {
+			if a[m.A] != b[m.B] {
+				t.Fatalf("%q, %q: matched unequal lines %v", a, b, m)
+			}
+			if j > 0 && (m.A <= matches[j-1].A || m.B <= matches[j-1].B) {
+				t.Fatalf("%q, %q: matches out of order: %v", a, b, matches)
+			}
+		}
+		if len(matches) != lcsLength(a, b) {
+			t.Fatalf("%q, %q: expected %d matches, got %d", a, b, lcsLength(a, b), len(matches))
+		}
+	}
+}
+
+func TestMatchesMemory(t *testing.T) {
+	// Entirely different files are the worst case, with as many edits as lines
+	a := make([]string, 3000)
+	b := make([]string, 3000)
+	for i := range a {
+		a[i] = "a" + strconv.Itoa(i) + "\n"
+		b[i] = "b" + strconv.Itoa(i) + "\n"
+	}
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	matches := diff.Matches(a, b)
+	runtime.ReadMemStats(&after)
+	assert.Equal(t, len(matches), 0)
+	if allocated := after.TotalAlloc - before.TotalAlloc; allocated > 64<<20 {
+		t.Errorf("expected memory use to be linear, allocated %d bytes", allocated)
+	}
+}
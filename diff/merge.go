@@ -0,0 +1,106 @@
+package diff
+
+const (
+	ConflictStart = "<<<<<<< "
+	ConflictSep   = "=======\n"
+	ConflictEnd   = ">>>>>>> "
+)
+
+// MergeLabels are the names written after conflict markers to identify each
+// side of a conflict.
+type MergeLabels struct {
+	Ours   string
+	Theirs string
+}
+
+// Merge performs a line-based three-way merge. Changes from base to ours and
+// from base to theirs are combined; where both sides changed the same region
+// differently, both versions are written surrounded by conflict markers.
+// Merge returns the merged content and the number of conflicting regions.
+func Merge(base, ours, theirs []byte, labels MergeLabels) ([]byte, int) {
+	baseLines := SplitLines(base)
+	ourLines := SplitLines(ours)
+	theirLines := SplitLines(theirs)
+
+	// For each base line, find the index of the matching line in ours and
+	// theirs, or -1 if it was changed or removed
+	ourMatch := matchIndex(len(baseLines), Matches(baseLines, ourLines))
+	theirMatch := matchIndex(len(baseLines), Matches(baseLines, theirLines))
+
+	merged := []string{}
+	conflicts := 0
+	b, o, t := 0, 0, 0
+	for b < len(baseLines) || o < len(ourLines) || t < len(theirLines) {
+		// A line is stable if it is present, unchanged, in all three versions
+		if b < len(baseLines) && ourMatch[b] == o && theirMatch[b] == t {
+			merged = append(merged, baseLines[b])
+			b, o, t = b+1, o+1, t+1
+			continue
+		}
+
+		// Find the next stable line; everything before it forms one chunk
+		nextB := b
+		for nextB < len(baseLines) && (ourMatch[nextB] < 0 || theirMatch[nextB] < 0) {
+			nextB++
+		}
+		nextO, nextT := len(ourLines), len(theirLines)
+		if nextB < len(baseLines) {
+			nextO, nextT = ourMatch[nextB], theirMatch[nextB]
+		}
+
+		baseChunk := baseLines[b:nextB]
+		ourChunk := ourLines[o:nextO]
+		theirChunk := theirLines[t:nextT]
+		switch {
+		case equalLines(ourChunk, baseChunk):
+			merged = append(merged, theirChunk...)
+		case equalLines(theirChunk, baseChunk), equalLines(ourChunk, theirChunk):
+			merged = append(merged, ourChunk...)
+		default:
+			conflicts++
+			merged = append(merged, ConflictStart+labels.Ours+"\n")
+			merged = appendTerminated(merged, ourChunk)
+			merged = append(merged, ConflictSep)
+			merged = appendTerminated(merged, theirChunk)
+			merged = append(merged, ConflictEnd+labels.Theirs+"\n")
+		}
+		b, o, t = nextB, nextO, nextT
+	}
+	return JoinLines(merged), conflicts
+}
+
+func matchIndex(n int, matches []Match) []int {
+	index := make([]int, n)
+	for i := range index {
+		index[i] = -1
+	}
+	for _, m := range matches {
+		index[m.A] = m.B
+	}
+	return index
+}
+
+func equalLines(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// appendTerminated appends lines to dst, making sure the last line ends with a
+// newline so that a following conflict marker starts on its own line.
+func appendTerminated(dst, lines []string) []string {
+	dst = append(dst, lines...)
+	if len(lines) > 0 {
+		last := lines[len(lines)-1]
+		if last[len(last)-1] != '\n' {
+			dst[len(dst)-1] = last + "\n"
+		}
+	}
+	return dst
+}
@@ -0,0 +1,55 @@
+package diff_test
+
+import (
+	"testing"
+
+	"github.com/olafal0/rescaffold/assert"
+	"github.com/olafal0/rescaffold/diff"
+)
+
+var labels = diff.MergeLabels{Ours: "current", Theirs: "scaffold"}
+
+func TestMergeNonOverlapping(t *testing.T) {
+	base := "package foo\n\nfunc A() {}\n\nfunc B() {}\n"
+	ours := "package foo\n\n// A does things\nfunc A() {}\n\nfunc B() {}\n"
+	theirs := "package foo\n\nfunc A() {}\n\nfunc B() {}\n\nfunc C() {}\n"
+
+	merged, conflicts := diff.Merge([]byte(base), []byte(ours), []byte(theirs), labels)
+	assert.Equal(t, conflicts, 0)
+	assert.Equal(t, string(merged), "package foo\n\n// A does things\nfunc A() {}\n\nfunc B() {}\n\nfunc C() {}\n")
+}
+
+func TestMergeSameChange(t *testing.T) {
+	base := "a\nb\nc\n"
+	changed := "a\nB\nc\n"
+	merged, conflicts := diff.Merge([]byte(base), []byte(changed), []byte(changed), labels)
+	assert.Equal(t, conflicts, 0)
+	assert.Equal(t, string(merged), changed)
+}
+
+func TestMergeConflict(t *testing.T) {
+	base := "a\nb\nc\n"
+	ours := "a\nours\nc\n"
+	theirs := "a\ntheirs\nc\n"
+	merged, conflicts := diff.Merge([]byte(base), []byte(ours), []byte(theirs), labels)
+	assert.Equal(t, conflicts, 1)
+	assert.Equal(t, string(merged), "a\n<<<<<<< current\nours\n=======\ntheirs\n>>>>>>> scaffold\nc\n")
+}
+
+func TestMergeNoTrailingNewline(t *testing.T) {
+	base := "a\nb"
+	ours := "x\nb"
+	theirs := "a\nb\nc"
+	merged, conflicts := diff.Merge([]byte(base), []byte(ours), []byte(theirs), labels)
+	assert.Equal(t, conflicts, 1)
+	assert.StrContains(t, string(merged), "x\n")
+}
+
+func TestMatches(t *testing.T) {
+	a := diff.SplitLines([]byte("a\nb\nc\nd\n"))
+	b := diff.SplitLines([]byte("b\nc\nx\nd\n"))
+	matches := diff.Matches(a, b)
+	assert.Equal(t, len(matches), 3)
+	assert.Equal(t, matches[0], diff.Match{A: 1, B: 0})
+	assert.Equal(t, matches[2], diff.Match{A: 3, B: 3})
+}
@@ -0,0 +1,105 @@
+package diff
+
+import (
+	"fmt"
+	"strings"
+)
+
+type lineOp struct {
+	op   byte
+	text string
+	// a and b are the indices of the line in the old and new versions; a is
+	// unused for insertions and b is unused for deletions
+	a, b int
+}
+
+// Unified returns a unified diff between a and b, with the given number of
+// lines of context around each change. It returns an empty string if a and b
+// are equal.
+func Unified(fromName, toName string, a, b []byte, context int) string {
+	aLines := SplitLines(a)
+	bLines := SplitLines(b)
+
+	ops := []lineOp{}
+	i, j := 0, 0
+	for _, m := range append(Matches(aLines, bLines), Match{len(aLines), len(bLines)}) {
+		for ; i < m.A; i++ {
+			ops = append(ops, lineOp{'-', aLines[i], i, j})
+		}
+		for ; j < m.B; j++ {
+			ops = append(ops, lineOp{'+', bLines[j], i, j})
+		}
+		if m.A < len(aLines) {
+			ops = append(ops, lineOp{' ', aLines[i], i, j})
+			i, j = i+1, j+1
+		}
+	}
+
+	out := &strings.Builder{}
+	for start := 0; start < len(ops); {
+		// Find the next change
+		for start < len(ops) && ops[start].op == ' ' {
+			start++
+		}
+		if start == len(ops) {
+			break
+		}
+		// Extend the hunk until there are more than 2*context unchanged lines
+		// following a change
+		end := start
+		for k := start; k < len(ops); k++ {
+			if ops[k].op != ' ' {
+				end = k + 1
+			} else if k-end >= 2*context {
+				break
+			}
+		}
+		hunkStart := start - context
+		if hunkStart < 0 {
+			hunkStart = 0
+		}
+		hunkEnd := end + context
+		if hunkEnd > len(ops) {
+			hunkEnd = len(ops)
+		}
+
+		if out.Len() == 0 {
+			fmt.Fprintf(out, "--- %s\n+++ %s\n", fromName, toName)
+		}
+		writeHunk(out, ops[hunkStart:hunkEnd])
+		start = hunkEnd
+	}
+	return out.String()
+}
+
+func writeHunk(out *strings.Builder, ops []lineOp) {
+	aStart, bStart := ops[0].a, ops[0].b
+	aLen, bLen := 0, 0
+	for _, op := range ops {
+		if op.op != '+' {
+			aLen++
+		}
+		if op.op != '-' {
+			bLen++
+		}
+	}
+	fmt.Fprintf(out, "@@ -%s +%s @@\n", hunkRange(aStart, aLen), hunkRange(bStart, bLen))
+	for _, op := range ops {
+		out.WriteByte(op.op)
+		out.WriteString(op.text)
+		if !strings.HasSuffix(op.text, "\n") {
+			out.WriteString("\n\\ No newline at end of file\n")
+		}
+	}
+}
+
+func hunkRange(start, length int) string {
+	if length == 0 {
+		// An empty range refers to the line before it
+		return fmt.Sprintf("%d,0", start)
+	}
+	if length == 1 {
+		return fmt.Sprintf("%d", start+1)
+	}
+	return fmt.Sprintf("%d,%d", start+1, length)
+}
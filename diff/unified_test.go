@@ -0,0 +1,29 @@
+package diff_test
+
+import (
+	"testing"
+
+	"github.com/olafal0/rescaffold/assert"
+	"github.com/olafal0/rescaffold/diff"
+)
+
+func TestUnified(t *testing.T) {
+	a := "a\nb\nc\nd\ne\nf\ng\nh\n"
+	b := "a\nb\nc\nD\ne\nf\ng\nh\ni"
+	expected := `--- old
++++ new
+@@ -2,7 +2,8 @@
+ b
+ c
+-d
++D
+ e
+ f
+ g
+ h
++i
+\ No newline at end of file
+`
+	assert.Equal(t, diff.Unified("old", "new", []byte(a), []byte(b), 2), expected)
+	assert.Equal(t, diff.Unified("old", "new", []byte(a), []byte(a), 2), "")
+}
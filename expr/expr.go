@@ -0,0 +1,388 @@
+// expr implements the small expression language used in scaffold manifests to
+// compute values from vars.
+//
+// An expression is a sequence of terms joined with "+", which concatenates
+// them. A term is a double-quoted string literal, a number, true or false, or a
+// var name optionally followed by a chain of modifiers, e.g.:
+//
+//	"github.com/acme/" + name|lowercase
+//
+// Values can be compared with "==" and "!=", and combined with "&&", "||" and
+// "!", e.g.:
+//
+//	use_docker == true && db != "none"
+//
+// All values are strings. Comparisons and logical operators produce "true" or
+// "false", and logical operators treat their operands according to Truthy.
+package expr
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Modifiers maps modifier names to the functions that apply them.
+type Modifiers map[string]func(string) string
+
+// Expr is a parsed expression.
+type Expr struct {
+	src  string
+	root node
+}
+
+type node interface {
+	eval(vars map[string]string, modifiers Modifiers) (string, error)
+	// collectVars adds the names of all vars referenced by the node to names
+	collectVars(names map[string]bool)
+}
+
+// Parse parses an expression. modifierDelim separates a var name from its
+// modifiers; if it is empty, "|" is used.
+func Parse(src, modifierDelim string) (*Expr, error) {
+	if modifierDelim == "" {
+		modifierDelim = "|"
+	}
+	p := &parser{src: src, modifierDelim: modifierDelim}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, fmt.Errorf("invalid expression %q: %w", src, err)
+	}
+	p.skipSpace()
+	if p.pos < len(p.src) {
+		return nil, fmt.Errorf("invalid expression %q: unexpected %q at position %d", src, p.src[p.pos:], p.pos)
+	}
+	return &Expr{src: src, root: root}, nil
+}
+
+// String returns the source of the expression.
+func (e *Expr) String() string {
+	return e.src
+}
+
+// Eval evaluates the expression using the given var values and modifiers.
+func (e *Expr) Eval(vars map[string]string, modifiers Modifiers) (string, error) {
+	return e.root.eval(vars, modifiers)
+}
+
+// EvalBool evaluates the expression, and reports whether the result is truthy.
+func (e *Expr) EvalBool(vars map[string]string, modifiers Modifiers) (bool, error) {
+	value, err := e.Eval(vars, modifiers)
+	if err != nil {
+		return false, err
+	}
+	return Truthy(value), nil
+}
+
+// Truthy reports whether a value counts as true: any value other than "",
+// "false", and "0".
+func Truthy(value string) bool {
+	return value != "" && value != "false" && value != "0"
+}
+
+func boolString(b bool) string {
+	return strconv.FormatBool(b)
+}
+
+// Vars returns the sorted names of all vars referenced by the expression.
+func (e *Expr) Vars() []string {
+	names := map[string]bool{}
+	e.root.collectVars(names)
+	sorted := make([]string, 0, len(names))
+	for name := range names {
+		sorted = append(sorted, name)
+	}
+	sort.Strings(sorted)
+	return sorted
+}
+
+type literal string
+
+func (l literal) eval(map[string]string, Modifiers) (string, error) {
+	return string(l), nil
+}
+
+func (l literal) collectVars(map[string]bool) {}
+
+type varRef struct {
+	name      string
+	modifiers []string
+}
+
+func (v *varRef) eval(vars map[string]string, modifiers Modifiers) (string, error) {
+	value, ok := vars[v.name]
+	if !ok {
+		return "", fmt.Errorf("undefined var %s", v.name)
+	}
+	for _, modifierName := range v.modifiers {
+		modifier, ok := modifiers[modifierName]
+		if !ok {
+			return "", fmt.Errorf("unknown modifier %s", modifierName)
+		}
+		value = modifier(value)
+	}
+	return value, nil
+}
+
+func (v *varRef) collectVars(names map[string]bool) {
+	names[v.name] = true
+}
+
+type concat []node
+
+func (c concat) eval(vars map[string]string, modifiers Modifiers) (string, error) {
+	builder := &strings.Builder{}
+	for _, n := range c {
+		value, err := n.eval(vars, modifiers)
+		if err != nil {
+			return "", err
+		}
+		builder.WriteString(value)
+	}
+	return builder.String(), nil
+}
+
+func (c concat) collectVars(names map[string]bool) {
+	for _, n := range c {
+		n.collectVars(names)
+	}
+}
+
+type compare struct {
+	op          string
+	left, right node
+}
+
+func (c *compare) eval(vars map[string]string, modifiers Modifiers) (string, error) {
+	left, err := c.left.eval(vars, modifiers)
+	if err != nil {
+		return "", err
+	}
+	right, err := c.right.eval(vars, modifiers)
+	if err != nil {
+		return "", err
+	}
+	if c.op == "==" {
+		return boolString(left == right), nil
+	}
+	return boolString(left != right), nil
+}
+
+func (c *compare) collectVars(names map[string]bool) {
+	c.left.collectVars(names)
+	c.right.collectVars(names)
+}
+
+type logical struct {
+	op          string
+	left, right node
+}
+
+func (l *logical) eval(vars map[string]string, modifiers Modifiers) (string, error) {
+	left, err := l.left.eval(vars, modifiers)
+	if err != nil {
+		return "", err
+	}
+	// Short-circuit, so that the right side may refer to vars that only exist
+	// when the left side allows it
+	if Truthy(left) == (l.op == "||") {
+		return boolString(Truthy(left)), nil
+	}
+	right, err := l.right.eval(vars, modifiers)
+	if err != nil {
+		return "", err
+	}
+	return boolString(Truthy(right)), nil
+}
+
+func (l *logical) collectVars(names map[string]bool) {
+	l.left.collectVars(names)
+	l.right.collectVars(names)
+}
+
+type not struct {
+	operand node
+}
+
+func (n *not) eval(vars map[string]string, modifiers Modifiers) (string, error) {
+	value, err := n.operand.eval(vars, modifiers)
+	if err != nil {
+		return "", err
+	}
+	return boolString(!Truthy(value)), nil
+}
+
+func (n *not) collectVars(names map[string]bool) {
+	n.operand.collectVars(names)
+}
+
+type parser struct {
+	src           string
+	pos           int
+	modifierDelim string
+}
+
+func (p *parser) skipSpace() {
+	for p.pos < len(p.src) && unicode.IsSpace(rune(p.src[p.pos])) {
+		p.pos++
+	}
+}
+
+// consume skips whitespace, then consumes s if it is next in the input.
+func (p *parser) consume(s string) bool {
+	p.skipSpace()
+	if strings.HasPrefix(p.src[p.pos:], s) {
+		p.pos += len(s)
+		return true
+	}
+	return false
+}
+
+func (p *parser) parseOr() (node, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.consume("||") {
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &logical{op: "||", left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseAnd() (node, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for p.consume("&&") {
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		left = &logical{op: "&&", left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseNot() (node, error) {
+	if p.consume("!") {
+		operand, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return &not{operand: operand}, nil
+	}
+	return p.parseCompare()
+}
+
+func (p *parser) parseCompare() (node, error) {
+	left, err := p.parseConcat()
+	if err != nil {
+		return nil, err
+	}
+	for _, op := range []string{"==", "!="} {
+		if p.consume(op) {
+			right, err := p.parseConcat()
+			if err != nil {
+				return nil, err
+			}
+			return &compare{op: op, left: left, right: right}, nil
+		}
+	}
+	return left, nil
+}
+
+func (p *parser) parseConcat() (node, error) {
+	first, err := p.parseTerm()
+	if err != nil {
+		return nil, err
+	}
+	terms := concat{first}
+	for p.consume("+") {
+		term, err := p.parseTerm()
+		if err != nil {
+			return nil, err
+		}
+		terms = append(terms, term)
+	}
+	if len(terms) == 1 {
+		return first, nil
+	}
+	return terms, nil
+}
+
+func (p *parser) parseTerm() (node, error) {
+	p.skipSpace()
+	if p.pos >= len(p.src) {
+		return nil, fmt.Errorf("unexpected end of expression")
+	}
+
+	switch c := p.src[p.pos]; {
+	case c == '(':
+		p.pos++
+		inner, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if !p.consume(")") {
+			return nil, fmt.Errorf("missing closing parenthesis")
+		}
+		return inner, nil
+	case c == '"':
+		quoted, err := strconv.QuotedPrefix(p.src[p.pos:])
+		if err != nil {
+			return nil, fmt.Errorf("invalid string at position %d", p.pos)
+		}
+		p.pos += len(quoted)
+		value, err := strconv.Unquote(quoted)
+		if err != nil {
+			return nil, err
+		}
+		return literal(value), nil
+	case c >= '0' && c <= '9':
+		start := p.pos
+		for p.pos < len(p.src) && (isDigit(p.src[p.pos]) || p.src[p.pos] == '.') {
+			p.pos++
+		}
+		return literal(p.src[start:p.pos]), nil
+	case isIdentStart(c):
+		name := p.parseIdent()
+		if name == "true" || name == "false" {
+			return literal(name), nil
+		}
+		ref := &varRef{name: name}
+		for strings.HasPrefix(p.src[p.pos:], p.modifierDelim) && !strings.HasPrefix(p.src[p.pos:], "||") {
+			p.pos += len(p.modifierDelim)
+			modifier := p.parseIdent()
+			if modifier == "" {
+				return nil, fmt.Errorf("missing modifier name at position %d", p.pos)
+			}
+			ref.modifiers = append(ref.modifiers, modifier)
+		}
+		return ref, nil
+	default:
+		return nil, fmt.Errorf("unexpected %q at position %d", c, p.pos)
+	}
+}
+
+func (p *parser) parseIdent() string {
+	start := p.pos
+	for p.pos < len(p.src) && (isIdentStart(p.src[p.pos]) || isDigit(p.src[p.pos])) {
+		p.pos++
+	}
+	return p.src[start:p.pos]
+}
+
+func isIdentStart(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
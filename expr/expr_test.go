@@ -0,0 +1,81 @@
+package expr_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/olafal0/rescaffold/assert"
+	"github.com/olafal0/rescaffold/expr"
+)
+
+var (
+	vars = map[string]string{
+		"name":       "MyApp",
+		"port":       "8080",
+		"use_docker": "true",
+		"db":         "none",
+	}
+	modifiers = expr.Modifiers{
+		"lowercase": strings.ToLower,
+		"uppercase": strings.ToUpper,
+	}
+)
+
+func testEval(t *testing.T, src string) string {
+	e, err := expr.Parse(src, "|")
+	if err != nil {
+		t.Fatal(err)
+	}
+	value, err := e.Eval(vars, modifiers)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return value
+}
+
+func TestConcat(t *testing.T) {
+	assert.Equal(t, testEval(t, `"github.com/acme/" + name|lowercase`), "github.com/acme/myapp")
+	assert.Equal(t, testEval(t, `name|lowercase|uppercase + "_" + port`), "MYAPP_8080")
+	assert.Equal(t, testEval(t, `("a" + "b") + 1`), "ab1")
+}
+
+func TestBool(t *testing.T) {
+	assert.Equal(t, testEval(t, `use_docker == true`), "true")
+	assert.Equal(t, testEval(t, `use_docker == true && db != "none"`), "false")
+	assert.Equal(t, testEval(t, `!use_docker || name|lowercase == "myapp"`), "true")
+	assert.Equal(t, testEval(t, `!(db == "none")`), "false")
+	assert.Equal(t, testEval(t, `port != 80 && !""`), "true")
+	// The right side isn't evaluated when the left side decides the result
+	assert.Equal(t, testEval(t, `db == "none" || missing`), "true")
+
+	assert.Equal(t, expr.Truthy("true"), true)
+	assert.Equal(t, expr.Truthy("0"), false)
+	assert.Equal(t, expr.Truthy(""), false)
+}
+
+func TestVars(t *testing.T) {
+	e, err := expr.Parse(`port + "/" + name|lowercase + name`, "|")
+	if err != nil {
+		t.Fatal(err)
+	}
+	vars := e.Vars()
+	assert.Equal(t, len(vars), 2)
+	assert.Equal(t, vars[0], "name")
+	assert.Equal(t, vars[1], "port")
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, src := range []string{``, `"unterminated`, `name +`, `name|`, `(name`, `name name`, `name ==`, `name && `, `!`} {
+		if _, err := expr.Parse(src, "|"); err == nil {
+			t.Errorf("expected error parsing %q", src)
+		}
+	}
+
+	e, err := expr.Parse(`missing`, "|")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := e.Eval(vars, modifiers); err == nil {
+		t.Error("expected error evaluating undefined var")
+	}
+}
@@ -0,0 +1,72 @@
+// glob matches slash-separated paths against glob patterns. Patterns use the
+// syntax of path.Match within each path segment, and a segment of "**" matches
+// any number of segments, including none, e.g. "docker/**/*.yml" matches both
+// "docker/compose.yml" and "docker/dev/compose.yml".
+package glob
+
+import (
+	"path"
+	"strings"
+)
+
+// Validate returns an error if pattern is malformed.
+func Validate(pattern string) error {
+	for _, segment := range split(pattern) {
+		if segment == "**" {
+			continue
+		}
+		if _, err := path.Match(segment, ""); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Match reports whether name matches pattern in full. Malformed patterns match
+// nothing.
+func Match(pattern, name string) bool {
+	return matchSegments(split(pattern), split(name))
+}
+
+// MatchPath reports whether pattern matches name or any of its parent
+// directories, so that a pattern naming a directory applies to everything
+// inside it.
+func MatchPath(pattern, name string) bool {
+	patternSegments := split(pattern)
+	nameSegments := split(name)
+	for i := len(nameSegments); i > 0; i-- {
+		if matchSegments(patternSegments, nameSegments[:i]) {
+			return true
+		}
+	}
+	return false
+}
+
+func split(p string) []string {
+	p = strings.Trim(path.Clean("/"+p), "/")
+	if p == "" {
+		return nil
+	}
+	return strings.Split(p, "/")
+}
+
+func matchSegments(pattern, name []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(name); i++ {
+				if matchSegments(pattern[1:], name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(name) == 0 {
+			return false
+		}
+		if ok, err := path.Match(pattern[0], name[0]); err != nil || !ok {
+			return false
+		}
+		pattern, name = pattern[1:], name[1:]
+	}
+	return len(name) == 0
+}
@@ -0,0 +1,76 @@
+package glob_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/olafal0/rescaffold/assert"
+	"github.com/olafal0/rescaffold/glob"
+)
+
+func TestMatch(t *testing.T) {
+	assert.Equal(t, glob.Match("*.go", "main.go"), true)
+	assert.Equal(t, glob.Match("*.go", "cmd/main.go"), false)
+	assert.Equal(t, glob.Match("docker/**", "docker/Dockerfile"), true)
+	assert.Equal(t, glob.Match("docker/**", "docker/dev/compose.yml"), true)
+	assert.Equal(t, glob.Match("docker/**/*.yml", "docker/compose.yml"), true)
+	assert.Equal(t, glob.Match("docker/**/*.yml", "docker/dev/compose.yml"), true)
+	assert.Equal(t, glob.Match("docker/**/*.yml", "docker/Dockerfile"), false)
+	assert.Equal(t, glob.Match("**/*.png", "img/logo.png"), true)
+	assert.Equal(t, glob.Match("/main.go", "main.go"), true)
+	assert.Equal(t, glob.Match("[", "["), false)
+}
+
+func TestMatchPath(t *testing.T) {
+	assert.Equal(t, glob.MatchPath("docker", "docker/dev/compose.yml"), true)
+	assert.Equal(t, glob.MatchPath("dock*", "docker/Dockerfile"), true)
+	assert.Equal(t, glob.MatchPath("docker", "web/docker.go"), false)
+	assert.Equal(t, glob.MatchPath("web/*.html", "/web/index.html"), true)
+}
+
+func TestValidate(t *testing.T) {
+	assert.Equal(t, glob.Validate("docker/**/*.yml"), nil)
+	if glob.Validate("[a-") == nil {
+		t.Error("expected error for malformed pattern")
+	}
+}
+
+func TestIgnore(t *testing.T) {
+	ignore, err := glob.ParseIgnore(strings.NewReader(`# scaffold docs
+README.md
+node_modules/
+/testdata
+ci/**/*.yml
+*.log
+!keep.log
+\#literal
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		name    string
+		isDir   bool
+		ignored bool
+		ok      bool
+	}{
+		{"README.md", false, true, true},
+		{"docs/README.md", false, true, true},
+		{"node_modules", true, true, true},
+		{"web/node_modules", true, true, true},
+		{"node_modules", false, false, false},
+		{"testdata", true, true, true},
+		{"pkg/testdata", true, false, false},
+		{"ci/github/build.yml", false, true, true},
+		{"debug.log", false, true, true},
+		{"keep.log", false, false, true},
+		{"#literal", false, true, true},
+		{"main.go", false, false, false},
+	}
+	for _, c := range cases {
+		ignored, ok := ignore.Match(c.name, c.isDir)
+		if ignored != c.ignored || ok != c.ok {
+			t.Errorf("%s: got ignored=%v ok=%v, expected ignored=%v ok=%v", c.name, ignored, ok, c.ignored, c.ok)
+		}
+	}
+}
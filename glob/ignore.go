@@ -0,0 +1,101 @@
+package glob
+
+import (
+	"bufio"
+	"io"
+	"path"
+	"strings"
+)
+
+// Ignore matches paths against a list of patterns with the semantics of
+// .gitignore files:
+//
+//   - blank lines and lines starting with "#" are skipped
+//   - a pattern starting with "!" re-includes paths matched by earlier patterns
+//   - a pattern ending with "/" only matches directories
+//   - a pattern containing a "/" other than at the end is matched against the
+//     whole path, relative to the directory of the ignore file; otherwise it is
+//     matched against the base name of the path, at any depth
+//
+// Later patterns take precedence over earlier ones.
+type Ignore struct {
+	patterns []ignorePattern
+}
+
+type ignorePattern struct {
+	pattern  string
+	negate   bool
+	dirOnly  bool
+	anchored bool
+}
+
+// ParseIgnore reads patterns from r, one per line.
+func ParseIgnore(r io.Reader) (*Ignore, error) {
+	ignore := &Ignore{}
+	if err := ignore.Read(r); err != nil {
+		return nil, err
+	}
+	return ignore, nil
+}
+
+// Read adds patterns read from r, one per line.
+func (ig *Ignore) Read(r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		if err := ig.Add(scanner.Text()); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+// Add adds a pattern, given as a line of an ignore file.
+func (ig *Ignore) Add(line string) error {
+	line = strings.TrimRight(line, " \t\r")
+	if line == "" || strings.HasPrefix(line, "#") {
+		return nil
+	}
+	p := ignorePattern{}
+	switch {
+	case strings.HasPrefix(line, "!"):
+		p.negate = true
+		line = line[1:]
+	case strings.HasPrefix(line, `\!`), strings.HasPrefix(line, `\#`):
+		line = line[1:]
+	}
+	if strings.HasSuffix(line, "/") {
+		p.dirOnly = true
+		line = strings.TrimRight(line, "/")
+	}
+	p.anchored = strings.Contains(line, "/")
+	p.pattern = strings.TrimPrefix(line, "/")
+	if p.pattern == "" {
+		return nil
+	}
+	if err := Validate(p.pattern); err != nil {
+		return err
+	}
+	ig.patterns = append(ig.patterns, p)
+	return nil
+}
+
+// Match reports whether name, a slash-separated path relative to the directory
+// of the ignore file, is ignored. ok is false if no pattern matches name, in
+// which case the decision is left to other ignore files.
+func (ig *Ignore) Match(name string, isDir bool) (ignored, ok bool) {
+	name = strings.Trim(path.Clean("/"+name), "/")
+	for i := len(ig.patterns) - 1; i >= 0; i-- {
+		p := ig.patterns[i]
+		if p.dirOnly && !isDir {
+			continue
+		}
+		target := name
+		if !p.anchored {
+			target = path.Base(name)
+		}
+		if Match(p.pattern, target) {
+			return !p.negate, true
+		}
+	}
+	return false, false
+}
@@ -5,6 +5,6 @@ go 1.20
 require (
 	github.com/BurntSushi/toml v1.3.2
 	github.com/chainguard-dev/git-urls v1.0.2
-	github.com/google/uuid v1.6.0
 	golang.org/x/text v0.10.0
+	gopkg.in/yaml.v3 v3.0.1
 )
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/olafal0/rescaffold/config"
+)
+
+var initCommand = &command{
+	name:    "init",
+	args:    "[flags] [dir]",
+	summary: "create a starter manifest for a new scaffold",
+	description: `Init creates a ` + config.ManifestFilename + ` file in dir, or the current
+directory if none is given, making it the root of a new scaffold.`,
+	setup: setupInit,
+}
+
+// starterManifest is the manifest created by init. It is formatted with the
+// scaffold's title.
+const starterManifest = `[meta]
+title = %q
+description = ""
+author = ""
+post_install = ""
+
+[config]
+open_delim = "x_"
+close_delim = "_"
+modifier_delim = "|"
+
+# Vars are referenced in file contents and paths as x_name_, or with a
+# modifier, as x_name|uppercase_
+[vars.name]
+type = "string"
+description = "A short, descriptive name for your project"
+`
+
+func setupInit(flags *flag.FlagSet) func(args []string) error {
+	title := flags.String("title", "", "title of the scaffold (default: the name of the directory)")
+	return func(args []string) error {
+		dir := "."
+		switch len(args) {
+		case 0:
+		case 1:
+			dir = args[0]
+		default:
+			usageError(flags, "init takes at most one directory")
+		}
+		return runInit(dir, *title)
+	}
+}
+
+// runInit creates a starter manifest in dir.
+func runInit(dir, title string) error {
+	if title == "" {
+		absDir, err := filepath.Abs(dir)
+		if err != nil {
+			return err
+		}
+		title = path.Base(filepath.ToSlash(absDir))
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	if _, err := os.Stat(path.Join(dir, config.LockfileFilename)); err == nil {
+		return fmt.Errorf("cannot create manifest in %s, %s exists", dir, config.LockfileFilename)
+	}
+	filename := path.Join(dir, config.ManifestFilename)
+	f, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0666)
+	if err != nil {
+		if os.IsExist(err) {
+			return fmt.Errorf("%s already exists", filename)
+		}
+		return err
+	}
+	defer f.Close()
+	if _, err := fmt.Fprintf(f, starterManifest, title); err != nil {
+		return err
+	}
+	fmt.Printf("created %s\n", filename)
+	return nil
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+
+	"github.com/olafal0/rescaffold/scaffold"
+)
+
+var lintCommand = &command{
+	name:    "lint",
+	args:    "[scaffold-dir]",
+	summary: "check a scaffold for mistakes",
+	description: `Lint checks the scaffold in scaffold-dir, or the current directory if none is
+given, for mistakes that would otherwise only be found when generating it:
+invalid var declarations, references to undeclared vars, declared vars that are
+never used, and empty delimiters. Each problem is printed with its location, and lint exits with
+status 1 if any are found.`,
+	setup: setupLint,
+}
+
+func setupLint(flags *flag.FlagSet) func(args []string) error {
+	return func(args []string) error {
+		dir := "."
+		switch len(args) {
+		case 0:
+		case 1:
+			dir = args[0]
+		default:
+			usageError(flags, "lint takes at most one scaffold directory")
+		}
+		problems, err := scaffold.Lint(dir)
+		if err != nil {
+			return err
+		}
+		for _, problem := range problems {
+			fmt.Println(problem)
+		}
+		if len(problems) > 0 {
+			return fmt.Errorf("found %d problems in %s", len(problems), dir)
+		}
+		return nil
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"text/tabwriter"
+
+	"github.com/olafal0/rescaffold/scaffold"
+	"github.com/olafal0/rescaffold/set"
+)
+
+var listCommand = &command{
+	name:    "list",
+	args:    "[flags] [source[@ref]]",
+	summary: "list installed scaffolds, or the scaffolds in a source",
+	description: `List prints the scaffolds installed in the project, with their sources,
+versions, and vars. If a source is given, it instead prints every scaffold
+found within the source, with its title.`,
+	setup: setupList,
+}
+
+func setupList(flags *flag.FlagSet) func(args []string) error {
+	outputDir := flags.String("out", ".", "directory containing the project's .rescaffold.toml")
+	offline := flags.Bool("offline", false, "use cached revisions of git scaffolds instead of fetching them")
+	return func(args []string) error {
+		switch len(args) {
+		case 0:
+			return listInstalled(*outputDir)
+		case 1:
+			return listSource(args[0], *offline)
+		}
+		usageError(flags, "list takes at most one source")
+		return nil
+	}
+}
+
+// listInstalled prints the scaffolds in the lockfile in dir.
+func listInstalled(dir string) error {
+	lockfile, err := openLockfile(dir)
+	if err != nil {
+		return err
+	}
+	defer lockfile.Close()
+	sources := set.Keys(lockfile.Scaffolds)
+	sort.Strings(sources)
+	for _, source := range sources {
+		lockedScaffold := lockfile.Scaffolds[source]
+		fmt.Println(source)
+		tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintf(tw, "  source\t%s\n", lockedScaffold.Source)
+		if lockedScaffold.Subdir != "" {
+			fmt.Fprintf(tw, "  subdir\t%s\n", lockedScaffold.Subdir)
+		}
+		if lockedScaffold.Ref != "" {
+			fmt.Fprintf(tw, "  ref\t%s\n", lockedScaffold.Ref)
+		}
+		if lockedScaffold.Commit != "" {
+			fmt.Fprintf(tw, "  commit\t%s\n", lockedScaffold.Commit)
+		}
+		names := set.Keys(lockedScaffold.Vars)
+		sort.Strings(names)
+		for _, name := range names {
+			fmt.Fprintf(tw, "  var\t%s = %s\n", name, lockedScaffold.Vars[name])
+		}
+		if err := tw.Flush(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// listSource prints the scaffolds found within a source.
+func listSource(s string, offline bool) error {
+	source, ref := scaffold.ParseSource(s)
+	discovered, err := scaffold.Discover(source, ref, offline)
+	if err != nil {
+		return err
+	}
+	if len(discovered) == 0 {
+		return fmt.Errorf("found no scaffolds in %s", source)
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	for _, found := range discovered {
+		title := ""
+		if found.Manifest.Meta != nil {
+			title = found.Manifest.Meta.Title
+		}
+		fmt.Fprintf(tw, "%s\t%s\n", found.Source, title)
+	}
+	return tw.Flush()
+}
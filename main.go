@@ -1,111 +1,272 @@
 package main
 
 import (
+	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"log"
+	"os"
 	"path"
+	"strings"
+	"text/tabwriter"
 
 	"github.com/olafal0/rescaffold/config"
 	"github.com/olafal0/rescaffold/scaffold"
-	"github.com/olafal0/rescaffold/set"
 )
 
-type Action int
+// command is a rescaffold subcommand.
+type command struct {
+	name string
+	// args describes the command's arguments in its usage line
+	args string
+	// summary is a one-line description, shown in the list of commands
+	summary string
+	// description is shown in the command's help text
+	description string
+	// setup registers the command's flags, and returns the function that runs
+	// the command with the arguments that remain after parsing them
+	setup func(flags *flag.FlagSet) func(args []string) error
+}
 
-const (
-	ActionUpgrade Action = iota
-	ActionRemove
-	ActionGenerate
-)
+// commands are listed in the order they are shown in the help text.
+var commands = []*command{
+	addCommand,
+	upgradeCommand,
+	removeCommand,
+	statusCommand,
+	diffCommand,
+	listCommand,
+	initCommand,
+	lintCommand,
+	cacheCommand,
+}
 
-func GetAction(shouldUpgrade, shouldRemove bool) Action {
-	switch {
-	case shouldUpgrade:
-		return ActionUpgrade
-	case shouldRemove:
-		return ActionRemove
-	default:
-		return ActionGenerate
+// flagSet returns a new set of flags for the command, with help text.
+func (c *command) flagSet() *flag.FlagSet {
+	flags := flag.NewFlagSet(c.name, flag.ExitOnError)
+	flags.Usage = func() {
+		out := flags.Output()
+		fmt.Fprintf(out, "Usage: rescaffold %s %s\n\n%s\n", c.name, c.args, c.description)
+		hasFlags := false
+		flags.VisitAll(func(*flag.Flag) { hasFlags = true })
+		if hasFlags {
+			fmt.Fprintln(out, "\nFlags:")
+			flags.PrintDefaults()
+		}
 	}
+	return flags
 }
 
-func main() {
-	var shouldUpgrade, shouldRemove bool
-	var outputDir string
-	flag.BoolVar(&shouldUpgrade, "upgrade", false, "upgrade specified scaffolds, or all scaffolds if none are specified")
-	flag.BoolVar(&shouldRemove, "remove", false, "remove specified scaffolds from the project")
-	flag.StringVar(&outputDir, "out", ".", "directory in which scaffold files are placed")
-	needHelp := flag.Bool("help", false, "print usage information")
-	flag.Parse()
-	scaffolds := flag.Args()
-
-	if shouldUpgrade && shouldRemove {
-		fmt.Println("Cannot upgrade and remove at the same time")
-		flag.Usage()
-		return
+// usageError prints msg and the command's help text, and exits.
+func usageError(flags *flag.FlagSet, msg string) {
+	fmt.Fprintf(flags.Output(), "%s\n\n", msg)
+	flags.Usage()
+	os.Exit(2)
+}
+
+func usage() {
+	out := os.Stderr
+	fmt.Fprintln(out, "Usage: rescaffold <command> [flags] [args]")
+	fmt.Fprintln(out, "\nCommands:")
+	tw := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	for _, cmd := range commands {
+		fmt.Fprintf(tw, "  %s\t%s\n", cmd.name, cmd.summary)
 	}
+	tw.Flush()
+	fmt.Fprintln(out, "\nRun \"rescaffold help <command>\" for more information about a command.")
+}
 
-	if *needHelp || (!shouldUpgrade && !shouldRemove && len(scaffolds) == 0) {
-		flag.Usage()
-		return
+func findCommand(name string) *command {
+	for _, cmd := range commands {
+		if cmd.name == name {
+			return cmd
+		}
 	}
+	return nil
+}
 
-	lockfilePath := path.Join(outputDir, config.LockfileFilename)
-	lockfile, err := config.LoadLockfile(lockfilePath)
-	if err != nil {
-		log.Fatal(fmt.Errorf("could not load lockfile: %w", err))
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+	name, args := os.Args[1], os.Args[2:]
+	switch name {
+	case "help", "-help", "--help", "-h":
+		if len(args) == 0 {
+			usage()
+			return
+		}
+		cmd := findCommand(args[0])
+		if cmd == nil {
+			fmt.Fprintf(os.Stderr, "unknown command %q\n\n", args[0])
+			usage()
+			os.Exit(2)
+		}
+		flags := cmd.flagSet()
+		cmd.setup(flags)
+		flags.Usage()
+		return
 	}
 
-	switch {
-	case shouldUpgrade:
-		err = UpgradeScaffolds(lockfile, scaffolds, outputDir)
-	case shouldRemove:
-		err = RemoveScaffolds(lockfile, scaffolds, outputDir)
-	default:
-		err = GenerateScaffolds(lockfile, scaffolds, outputDir)
+	cmd := findCommand(name)
+	if cmd == nil {
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n", name)
+		usage()
+		os.Exit(2)
 	}
-	if err != nil {
-		if lockfile.IsNewlyCreated() {
-			lockfile.Remove()
+	flags := cmd.flagSet()
+	run := cmd.setup(flags)
+	flags.Parse(args)
+	if err := run(flags.Args()); err != nil {
+		if errors.Is(err, errExitStatus) {
+			os.Exit(1)
 		}
 		log.Fatal(err)
 	}
 }
 
-func UpgradeScaffolds(lockfile *config.Lockfile, scaffolds []string, outdir string) error {
-	if len(scaffolds) == 0 {
-		scaffolds = set.Keys(lockfile.Scaffolds)
+// errExitStatus is returned by commands that have already printed their
+// result, and only need rescaffold to exit with status 1, once their deferred
+// cleanup has run.
+var errExitStatus = errors.New("exit status 1")
+
+// varFlags collects the values of repeated -var name=value flags
+type varFlags map[string]string
+
+func (v varFlags) String() string {
+	pairs := make([]string, 0, len(v))
+	for name, value := range v {
+		pairs = append(pairs, name+"="+value)
 	}
-	for _, s := range scaffolds {
-		err := scaffold.Upgrade(lockfile, path.Clean(s), outdir)
-		if err != nil {
-			return err
-		}
+	return strings.Join(pairs, ",")
+}
+
+func (v varFlags) Set(s string) error {
+	name, value, ok := strings.Cut(s, "=")
+	if !ok || name == "" {
+		return fmt.Errorf("vars must be given as name=value")
 	}
+	v[name] = value
 	return nil
 }
 
-func RemoveScaffolds(lockfile *config.Lockfile, scaffolds []string, outdir string) error {
-	if len(scaffolds) == 0 {
-		return fmt.Errorf("will not remove all scaffolds without specifying them explicitly")
+// planFlags are the flags shared by the commands that change the project.
+type planFlags struct {
+	outputDir      string
+	dryRun         bool
+	conflict       string
+	vars           varFlags
+	varsFile       string
+	nonInteractive bool
+	offline        bool
+}
+
+// addPlanFlags registers the flags shared by the commands that change the
+// project. The -conflict flag is only registered if conflicts is set.
+func addPlanFlags(flags *flag.FlagSet, conflicts bool) *planFlags {
+	pf := &planFlags{vars: varFlags{}}
+	flags.StringVar(&pf.outputDir, "out", ".", "directory in which scaffold files are placed")
+	flags.BoolVar(&pf.dryRun, "dry-run", false, "print the changes that would be made, without making them")
+	if conflicts {
+		flags.StringVar(&pf.conflict, "conflict", string(scaffold.ConflictAsk), "how to resolve files that exist but are untracked, or have been modified and can't be merged: "+
+			"ask (prompt for each file), ours (keep existing file), theirs (overwrite with scaffold version), "+
+			"backup (keep existing file and write scaffold version to a "+scaffold.SidecarSuffix+" file), or diff (print a diff and abort)")
+	}
+	flags.Var(pf.vars, "var", "set the value of a scaffold var, as name=value (may be repeated)")
+	flags.StringVar(&pf.varsFile, "vars-file", "", "load scaffold var values from a .toml, .json, or .yaml file")
+	flags.BoolVar(&pf.nonInteractive, "non-interactive", false, "never prompt for input; fail if any required vars are missing")
+	flags.BoolVar(&pf.offline, "offline", false, "use cached revisions of git scaffolds instead of fetching them")
+	return pf
+}
+
+// options returns the options for planning changes set by the flags.
+func (pf *planFlags) options() (scaffold.Options, error) {
+	opts := scaffold.Options{
+		Vars:           map[string]string{},
+		NonInteractive: pf.nonInteractive,
+		Offline:        pf.offline,
 	}
-	for _, s := range scaffolds {
-		err := scaffold.Remove(lockfile, path.Clean(s), outdir)
+	if pf.conflict != "" {
+		policy, err := scaffold.ParseConflictPolicy(pf.conflict)
 		if err != nil {
-			return err
+			return opts, err
 		}
+		opts.Conflict = policy
 	}
-	return nil
+	if pf.varsFile != "" {
+		vars, err := config.LoadValuesFile(pf.varsFile)
+		if err != nil {
+			return opts, err
+		}
+		opts.Vars = vars
+	}
+	// Values given with -var take precedence over the vars file
+	for name, value := range pf.vars {
+		opts.Vars[name] = value
+	}
+	if pf.dryRun && opts.Conflict == scaffold.ConflictAsk {
+		// Don't prompt during a dry run; conflicts are shown in the plan instead
+		opts.Conflict = ""
+	}
+	return opts, nil
 }
 
-func GenerateScaffolds(lockfile *config.Lockfile, scaffolds []string, outdir string) error {
-	if len(scaffolds) == 0 {
-		return fmt.Errorf("cannot generate scaffolds if none are specified. to upgrade, use the -upgrade flag")
+// runPlans plans changes to the project, then applies them, or prints them if
+// -dry-run is set.
+func (pf *planFlags) runPlans(plan func(lockfile *config.Lockfile, opts scaffold.Options) ([]*scaffold.Plan, error)) error {
+	opts, err := pf.options()
+	if err != nil {
+		return err
 	}
-	for _, s := range scaffolds {
-		err := scaffold.Generate(lockfile, path.Clean(s), outdir)
-		if err != nil {
+	lockfilePath := path.Join(pf.outputDir, config.LockfileFilename)
+	loadLockfile := config.LoadLockfile
+	if pf.dryRun {
+		// A dry run must not touch the project, so the lockfile isn't created
+		loadLockfile = config.ReadLockfile
+	}
+	lockfile, err := loadLockfile(lockfilePath)
+	if err != nil {
+		return fmt.Errorf("could not load lockfile: %w", err)
+	}
+	defer lockfile.Close()
+
+	plans, err := plan(lockfile, opts)
+	if err == nil {
+		if pf.dryRun {
+			err = PrintPlans(os.Stdout, plans)
+		} else {
+			err = scaffold.Apply(lockfile, plans...)
+		}
+	}
+	if !pf.dryRun && err != nil && lockfile.IsNewlyCreated() {
+		lockfile.Remove()
+	}
+	return err
+}
+
+// openLockfile loads the lockfile in dir read-only, without creating it if it
+// doesn't exist. The lockfile must be closed once the command is done with it.
+func openLockfile(dir string) (*config.Lockfile, error) {
+	filename := path.Join(dir, config.LockfileFilename)
+	if _, err := os.Stat(filename); err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("no %s in %s, add a scaffold first", config.LockfileFilename, dir)
+		}
+		return nil, err
+	}
+	lockfile, err := config.ReadLockfile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("could not load lockfile: %w", err)
+	}
+	return lockfile, nil
+}
+
+// PrintPlans writes a table of the actions in each plan to w.
+func PrintPlans(w io.Writer, plans []*scaffold.Plan) error {
+	for _, plan := range plans {
+		if err := plan.WriteTable(w); err != nil {
 			return err
 		}
 	}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"flag"
+	"strings"
+	"testing"
+
+	"github.com/olafal0/rescaffold/assert"
+	"github.com/olafal0/rescaffold/scaffold"
+)
+
+func TestCommandFlags(t *testing.T) {
+	names := map[string]bool{}
+	for _, cmd := range commands {
+		if names[cmd.name] {
+			t.Errorf("command %s is defined more than once", cmd.name)
+		}
+		names[cmd.name] = true
+		if cmd.summary == "" || cmd.description == "" {
+			t.Errorf("command %s has no help text", cmd.name)
+		}
+
+		flags := cmd.flagSet()
+		flags.Init(cmd.name, flag.ContinueOnError)
+		if run := cmd.setup(flags); run == nil {
+			t.Errorf("command %s has no run function", cmd.name)
+			continue
+		}
+		// Every flag accepts its own default value
+		args := []string{}
+		flags.VisitAll(func(f *flag.Flag) {
+			if f.DefValue != "" {
+				args = append(args, "-"+f.Name+"="+f.DefValue)
+			}
+		})
+		args = append(args, "arg")
+		if err := flags.Parse(args); err != nil {
+			t.Errorf("command %s: %v", cmd.name, err)
+			continue
+		}
+		if flags.NArg() != 1 || flags.Arg(0) != "arg" {
+			t.Errorf("command %s: expected arguments to remain after flags, got %v", cmd.name, flags.Args())
+		}
+	}
+	if findCommand("upgrade") == nil || findCommand("nonexistent") != nil {
+		t.Error("findCommand found the wrong commands")
+	}
+}
+
+func TestPrintPlans(t *testing.T) {
+	plans := []*scaffold.Plan{
+		{Source: "first", Actions: []*scaffold.Action{{Kind: scaffold.ActionCreate, Path: "a.txt"}}},
+		{Source: "second", Actions: []*scaffold.Action{{Kind: scaffold.ActionSkipExisting, Path: "b.txt", Detail: "kept"}}},
+	}
+	buf := &strings.Builder{}
+	if err := PrintPlans(buf, plans); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, buf.String(), "first\n  create  a.txt\nsecond\n  skip-existing  b.txt  kept\n")
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"flag"
+
+	"github.com/olafal0/rescaffold/config"
+	"github.com/olafal0/rescaffold/scaffold"
+)
+
+var removeCommand = &command{
+	name:    "remove",
+	args:    "[flags] <source>...",
+	summary: "remove scaffolds from the project",
+	description: `Remove deletes the files generated by the given scaffolds, and stops tracking
+them in .rescaffold.toml. Files you have modified since they were generated are
+left in place.`,
+	setup: setupRemove,
+}
+
+func setupRemove(flags *flag.FlagSet) func(args []string) error {
+	pf := addPlanFlags(flags, false)
+	return func(args []string) error {
+		if len(args) == 0 {
+			usageError(flags, "will not remove all scaffolds without specifying them explicitly")
+		}
+		return pf.runPlans(func(lockfile *config.Lockfile, opts scaffold.Options) ([]*scaffold.Plan, error) {
+			return PlanRemovals(lockfile, args, pf.outputDir, opts)
+		})
+	}
+}
+
+func PlanRemovals(lockfile *config.Lockfile, scaffolds []string, outdir string, opts scaffold.Options) ([]*scaffold.Plan, error) {
+	plans := make([]*scaffold.Plan, 0, len(scaffolds))
+	for _, s := range scaffolds {
+		source, _ := scaffold.ParseSource(s)
+		plan, err := scaffold.PlanRemove(lockfile, source, outdir, opts)
+		if err != nil {
+			return nil, err
+		}
+		plans = append(plans, plan)
+	}
+	return plans, nil
+}
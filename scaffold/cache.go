@@ -0,0 +1,339 @@
+package scaffold
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Git sources are cached in a directory per source, named after a hash of the
+// source URL. Each directory contains a bare repository holding the commits
+// fetched so far, and a checkout of each commit that has been loaded, named
+// after the commit.
+const cacheRepoDir = "repo"
+
+// cacheRefPrefix is the prefix of the refs in cached repositories that record
+// the commit each requested ref last resolved to, so that refs can be resolved
+// offline.
+const cacheRefPrefix = "refs/rescaffold/"
+
+// CacheDir returns the directory in which git sources are cached.
+func CacheDir() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", fmt.Errorf("could not find cache directory: %w", err)
+	}
+	return path.Join(dir, "rescaffold"), nil
+}
+
+// sourceCache is the cache of a single git source.
+type sourceCache struct {
+	source string
+	dir    string
+	// offline disables fetching, so only cached commits can be used
+	offline bool
+}
+
+func cacheKey(source string) string {
+	sum := sha256.Sum256([]byte(source))
+	return hex.EncodeToString(sum[:16])
+}
+
+// openSourceCache opens the cache of source within root. Unless offline is set,
+// the cache is created if it doesn't exist.
+func openSourceCache(root, source string, offline bool) (*sourceCache, error) {
+	if strings.HasPrefix(source, "-") {
+		return nil, fmt.Errorf("invalid git source %q", source)
+	}
+	c := &sourceCache{
+		source:  source,
+		dir:     path.Join(root, cacheKey(source)),
+		offline: offline,
+	}
+	if _, err := os.Stat(c.repo()); err == nil {
+		touch(c.dir)
+		return c, nil
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+	if offline {
+		return nil, fmt.Errorf("%s is not cached, and can't be fetched offline", source)
+	}
+
+	// The repository is set up in a temporary directory and then moved into
+	// place, so that a partially set up repository is never used
+	if err := os.MkdirAll(c.dir, 0755); err != nil {
+		return nil, fmt.Errorf("could not create cache directory: %w", err)
+	}
+	tempDir, err := os.MkdirTemp(c.dir, "repo-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tempDir)
+	if _, err := runGit(tempDir, "init", "--quiet", "--bare"); err != nil {
+		return nil, err
+	}
+	if _, err := runGit(tempDir, "remote", "add", "origin", source); err != nil {
+		return nil, err
+	}
+	if err := os.Rename(tempDir, c.repo()); err != nil {
+		// Another process may have set up the repository first
+		if _, statErr := os.Stat(c.repo()); statErr != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// repo returns the directory of the cached bare repository.
+func (c *sourceCache) repo() string {
+	return path.Join(c.dir, cacheRepoDir)
+}
+
+// resolve returns the commit that ref refers to, or the latest commit on the
+// default branch if ref is empty. The source is only fetched from if ref is not
+// a commit that is already cached. Offline, refs resolve to the commit they
+// resolved to when they were last fetched.
+func (c *sourceCache) resolve(ref string) (string, error) {
+	if strings.HasPrefix(ref, "-") {
+		return "", fmt.Errorf("invalid git ref %q", ref)
+	}
+	// Commits never change, so there's no need to fetch one that's cached
+	if isCommitHash(ref) && c.revParse(ref) == ref {
+		return ref, nil
+	}
+
+	cacheRef := cacheRefPrefix + "default"
+	if ref != "" {
+		cacheRef = cacheRefPrefix + "ref/" + ref
+	}
+	if c.offline {
+		if commit := c.revParse(cacheRef); commit != "" {
+			return commit, nil
+		}
+		// Abbreviated commit hashes and tags can be found if the whole
+		// repository was fetched
+		if ref != "" {
+			if commit := c.revParse(ref); commit != "" {
+				return commit, nil
+			}
+			return "", fmt.Errorf("%s@%s is not cached, and can't be fetched offline", c.source, ref)
+		}
+		return "", fmt.Errorf("%s is not cached, and can't be fetched offline", c.source)
+	}
+
+	fetchRef := ref
+	if fetchRef == "" {
+		fetchRef = "HEAD"
+	}
+	var commit string
+	if _, err := runGit(c.repo(), "fetch", "--quiet", "--depth", "1", "origin", fetchRef); err == nil {
+		commit = c.revParse("FETCH_HEAD")
+	} else {
+		if ref == "" {
+			return "", fmt.Errorf("could not fetch %s: %w", c.source, err)
+		}
+		// Refs that can't be fetched directly, such as abbreviated commit hashes,
+		// require fetching the whole repository
+		args := []string{"fetch", "--quiet", "--tags", "origin"}
+		if out, _ := runGit(c.repo(), "rev-parse", "--is-shallow-repository"); strings.TrimSpace(out) == "true" {
+			args = append(args, "--unshallow")
+		}
+		if _, fullErr := runGit(c.repo(), args...); fullErr != nil {
+			return "", fmt.Errorf("could not fetch %s: %w", c.source, fullErr)
+		}
+		commit = c.revParse(ref)
+	}
+	if commit == "" {
+		return "", fmt.Errorf("could not find %s in %s", fetchRef, c.source)
+	}
+
+	// Record the commit, so that the ref can be resolved offline. Refs that
+	// aren't valid ref names can't be recorded, so they can only be resolved
+	// offline if they can be found in the repository.
+	runGit(c.repo(), "update-ref", cacheRef, commit)
+	return commit, nil
+}
+
+// revParse returns the commit that rev refers to in the cached repository, or
+// an empty string if it can't be found.
+func (c *sourceCache) revParse(rev string) string {
+	out, err := runGit(c.repo(), "rev-parse", "--verify", "--quiet", rev+"^{commit}")
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(out)
+}
+
+// checkout returns a directory containing a checkout of commit, which must
+// already be in the cached repository. Checkouts are reused once created.
+func (c *sourceCache) checkout(commit string) (string, error) {
+	dir := path.Join(c.dir, commit)
+	if _, err := os.Stat(dir); err == nil {
+		touch(dir)
+		return dir, nil
+	}
+
+	// Like the repository, checkouts are moved into place once complete
+	tempDir, err := os.MkdirTemp(c.dir, "checkout-")
+	if err != nil {
+		return "", err
+	}
+	if _, err := runGit(c.repo(), "worktree", "add", "--quiet", "--detach", tempDir, commit); err != nil {
+		os.RemoveAll(tempDir)
+		runGit(c.repo(), "worktree", "prune")
+		return "", fmt.Errorf("could not check out %s: %w", shortCommit(commit), err)
+	}
+	if _, err := runGit(c.repo(), "worktree", "move", tempDir, dir); err != nil {
+		runGit(c.repo(), "worktree", "remove", "--force", tempDir)
+		// Another process may have checked out the commit first
+		if _, statErr := os.Stat(dir); statErr != nil {
+			return "", fmt.Errorf("could not check out %s: %w", shortCommit(commit), err)
+		}
+	}
+	return dir, nil
+}
+
+// touch records that a cached directory was used, so that it isn't pruned.
+func touch(dir string) {
+	now := time.Now()
+	os.Chtimes(dir, now, now)
+}
+
+// isCommitHash reports whether s is a full SHA-1 or SHA-256 commit hash.
+func isCommitHash(s string) bool {
+	if len(s) != 40 && len(s) != 64 {
+		return false
+	}
+	_, err := hex.DecodeString(s)
+	return err == nil && strings.ToLower(s) == s
+}
+
+// gitCacheRoot returns the directory in which a git source is cached, and a
+// function that cleans it up after use. Local repositories can be read
+// directly, so they aren't cached; they are fetched into a temporary directory
+// instead, which is also used if there is no cache directory.
+func gitCacheRoot(source string) (root string, cleanup func() error, err error) {
+	if isGitURL(source) {
+		if root, err := CacheDir(); err == nil {
+			return root, func() error { return nil }, nil
+		}
+	}
+	root, err = os.MkdirTemp("", "rescaffold-")
+	if err != nil {
+		return "", nil, err
+	}
+	return root, func() error { return os.RemoveAll(root) }, nil
+}
+
+// CachedSource is a git source in the cache.
+type CachedSource struct {
+	// Source is the URL the source is fetched from. It is empty if the cache
+	// of the source is incomplete.
+	Source string
+	// Dir is the directory containing the source's cache
+	Dir     string
+	Commits []CachedCommit
+	// Size is the total size of the source's cache, in bytes
+	Size int64
+}
+
+// CachedCommit is a checkout of a commit in the cache.
+type CachedCommit struct {
+	Commit   string
+	LastUsed time.Time
+}
+
+// ListCache returns the git sources cached in root, sorted by source. Each
+// source's commits are sorted with the most recently used first.
+func ListCache(root string) ([]CachedSource, error) {
+	entries, err := os.ReadDir(root)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	sources := []CachedSource{}
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		cached := CachedSource{Dir: path.Join(root, entry.Name())}
+		if out, err := runGit(path.Join(cached.Dir, cacheRepoDir), "config", "--get", "remote.origin.url"); err == nil {
+			cached.Source = strings.TrimSpace(out)
+		}
+
+		err := filepath.WalkDir(cached.Dir, func(filePath string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			if d.IsDir() && path.Dir(filePath) == cached.Dir && isCommitHash(d.Name()) {
+				cached.Commits = append(cached.Commits, CachedCommit{
+					Commit:   d.Name(),
+					LastUsed: info.ModTime(),
+				})
+			}
+			if info.Mode().IsRegular() {
+				cached.Size += info.Size()
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		sort.Slice(cached.Commits, func(i, j int) bool {
+			return cached.Commits[i].LastUsed.After(cached.Commits[j].LastUsed)
+		})
+		sources = append(sources, cached)
+	}
+	sort.Slice(sources, func(i, j int) bool {
+		return sources[i].Source < sources[j].Source
+	})
+	return sources, nil
+}
+
+// PruneCache removes the checkouts in the cache in root that haven't been used
+// within maxAge, along with the repositories of sources with no checkouts left,
+// and returns the number of checkouts removed. If maxAge is 0, the whole cache
+// is removed.
+func PruneCache(root string, maxAge time.Duration) (removed int, err error) {
+	sources, err := ListCache(root)
+	if err != nil {
+		return 0, err
+	}
+	cutoff := time.Now().Add(-maxAge)
+	for _, cached := range sources {
+		remaining := 0
+		for _, commit := range cached.Commits {
+			if maxAge > 0 && commit.LastUsed.After(cutoff) {
+				remaining++
+				continue
+			}
+			if err := os.RemoveAll(path.Join(cached.Dir, commit.Commit)); err != nil {
+				return removed, err
+			}
+			removed++
+		}
+		if remaining == 0 {
+			if err := os.RemoveAll(cached.Dir); err != nil {
+				return removed, err
+			}
+			continue
+		}
+		runGit(path.Join(cached.Dir, cacheRepoDir), "worktree", "prune")
+	}
+	return removed, nil
+}
@@ -0,0 +1,158 @@
+package scaffold
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/olafal0/rescaffold/config"
+	"github.com/olafal0/rescaffold/diff"
+)
+
+// ConflictPolicy determines how files that can't be safely generated are
+// handled: files that exist but aren't tracked in the lockfile, and tracked
+// files that have been modified when the scaffold's changes can't be merged.
+type ConflictPolicy string
+
+const (
+	// ConflictAsk prompts for a resolution of each conflict
+	ConflictAsk ConflictPolicy = "ask"
+	// ConflictOurs keeps the existing file
+	ConflictOurs ConflictPolicy = "ours"
+	// ConflictTheirs overwrites the existing file with the scaffold's version
+	ConflictTheirs ConflictPolicy = "theirs"
+	// ConflictBackup keeps the existing file, and writes the scaffold's version
+	// next to it with the SidecarSuffix extension
+	ConflictBackup ConflictPolicy = "backup"
+	// ConflictDiff prints a diff of each conflict and leaves it unresolved
+	ConflictDiff ConflictPolicy = "diff"
+)
+
+// SidecarSuffix is appended to the name of a file to store the scaffold's
+// version of it alongside the existing file. Sidecar files aren't tracked in the
+// lockfile, so they are left in place when the scaffold is removed.
+const SidecarSuffix = ".rescaffold-new"
+
+var conflictPolicies = []ConflictPolicy{ConflictAsk, ConflictOurs, ConflictTheirs, ConflictBackup, ConflictDiff}
+
+// ParseConflictPolicy parses the name of a conflict policy.
+func ParseConflictPolicy(s string) (ConflictPolicy, error) {
+	for _, policy := range conflictPolicies {
+		if string(policy) == s {
+			return policy, nil
+		}
+	}
+	return "", fmt.Errorf("unknown conflict policy %q, must be one of %v", s, conflictPolicies)
+}
+
+// Options configure how scaffolds are planned.
+type Options struct {
+	// Conflict is the policy used to resolve conflicts. If empty, conflicts are
+	// left unresolved, and applying the plan will fail.
+	Conflict ConflictPolicy
+	// Vars are var values supplied up front, which take precedence over values
+	// in the lockfile
+	Vars map[string]string
+	// NonInteractive disables all prompts. Missing vars are an error, and
+	// conflicts are left unresolved under the ask policy.
+	NonInteractive bool
+	// Offline loads git sources from the cache instead of fetching them. Only
+	// commits that have been fetched before can be used.
+	Offline bool
+}
+
+// conflict is a file where the scaffold's content can't be written without
+// either losing the existing content or ignoring the scaffold's.
+type conflict struct {
+	path   string
+	reason string
+	// tracked is true if the file is tracked in the lockfile
+	tracked bool
+	// current is the content of the existing file
+	current []byte
+	// rendered is the content generated by the scaffold
+	rendered []byte
+	checksum string
+	mode     fs.FileMode
+}
+
+// resolveConflict adds the actions resolving a conflict to a plan, according to
+// the conflict policy. lockedScaffold is updated to track the file if the
+// scaffold's version is written.
+func (opts Options) resolveConflict(plan *Plan, lockedScaffold *config.LockfileScaffold, c conflict) error {
+	policy := opts.Conflict
+	if policy == ConflictAsk && !opts.NonInteractive {
+		var err error
+		policy, err = askConflict(c)
+		if err != nil {
+			return err
+		}
+	}
+
+	switch policy {
+	case ConflictOurs:
+		plan.add(c.skipKind(), c.path, c.reason+", keeping existing file")
+	case ConflictTheirs:
+		plan.add(ActionOverwrite, c.path, c.reason+", overwriting").setContent(c.rendered, c.mode)
+		trackFile(lockedScaffold, c.path, c.checksum, c.mode)
+	case ConflictBackup:
+		plan.add(c.skipKind(), c.path, c.reason+", keeping existing file")
+		sidecar := c.path + SidecarSuffix
+		kind, detail := ActionCreate, "scaffold version of "+c.path
+		if _, err := os.Lstat(sidecar); err == nil {
+			kind, detail = ActionOverwrite, detail+", replacing existing "+path.Base(sidecar)
+		} else if !os.IsNotExist(err) {
+			return err
+		}
+		plan.add(kind, sidecar, detail).setContent(c.rendered, c.mode)
+	case ConflictDiff:
+		fmt.Print(conflictDiff(c))
+		plan.add(ActionConflict, c.path, c.reason)
+	default:
+		plan.add(ActionConflict, c.path, c.reason)
+	}
+	return nil
+}
+
+// skipKind returns the kind of action that leaves the conflicting file in place
+func (c conflict) skipKind() ActionKind {
+	if c.tracked {
+		return ActionSkipModified
+	}
+	return ActionSkipExisting
+}
+
+func conflictDiff(c conflict) string {
+	if isBinary(c.current) || isBinary(c.rendered) {
+		return fmt.Sprintf("Binary files %s and %s (scaffold) differ\n", c.path, c.path)
+	}
+	return diff.Unified(c.path, c.path+" (scaffold)", c.current, c.rendered, 3)
+}
+
+// askConflict prompts for how to resolve a conflict, and returns the chosen
+// policy.
+func askConflict(c conflict) (ConflictPolicy, error) {
+	fmt.Printf("conflict: %s (%s)\n", c.path, c.reason)
+	fmt.Print(conflictDiff(c))
+	for {
+		fmt.Printf("[k]eep existing file, [o]verwrite with scaffold version, or write scaffold version to [s]idecar %s? ", c.path+SidecarSuffix)
+		answer, err := readLine()
+		if err == io.EOF {
+			return "", fmt.Errorf("could not resolve conflict in %s: no input available", c.path)
+		}
+		if err != nil {
+			return "", fmt.Errorf("could not resolve conflict in %s: %w", c.path, err)
+		}
+		switch strings.ToLower(strings.TrimSpace(answer)) {
+		case "k", "keep":
+			return ConflictOurs, nil
+		case "o", "overwrite":
+			return ConflictTheirs, nil
+		case "s", "sidecar":
+			return ConflictBackup, nil
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package scaffold_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/olafal0/rescaffold/assert"
+	"github.com/olafal0/rescaffold/config"
+	"github.com/olafal0/rescaffold/scaffold"
+)
+
+// testActions returns the kind of each action in a plan, by path.
+func testActions(plan *scaffold.Plan) map[string]scaffold.ActionKind {
+	actions := map[string]scaffold.ActionKind{}
+	for _, action := range plan.Actions {
+		actions[filepath.Base(action.Path)] = action.Kind
+	}
+	return actions
+}
+
+func TestConflictPolicies(t *testing.T) {
+	scaffoldDir := t.TempDir()
+	testWriteFiles(t, scaffoldDir, map[string]string{
+		config.ManifestFilename: testManifest,
+		"file.txt":              "scaffold\n",
+	})
+
+	tests := []struct {
+		policy scaffold.ConflictPolicy
+		// sidecar is set if a sidecar file already exists
+		sidecar  bool
+		expected map[string]scaffold.ActionKind
+	}{
+		{"", false, map[string]scaffold.ActionKind{"file.txt": scaffold.ActionConflict}},
+		{scaffold.ConflictAsk, false, map[string]scaffold.ActionKind{"file.txt": scaffold.ActionConflict}},
+		{scaffold.ConflictOurs, false, map[string]scaffold.ActionKind{"file.txt": scaffold.ActionSkipExisting}},
+		{scaffold.ConflictTheirs, false, map[string]scaffold.ActionKind{"file.txt": scaffold.ActionOverwrite}},
+		{scaffold.ConflictDiff, false, map[string]scaffold.ActionKind{"file.txt": scaffold.ActionConflict}},
+		{scaffold.ConflictBackup, false, map[string]scaffold.ActionKind{
+			"file.txt":                          scaffold.ActionSkipExisting,
+			"file.txt" + scaffold.SidecarSuffix: scaffold.ActionCreate,
+		}},
+		{scaffold.ConflictBackup, true, map[string]scaffold.ActionKind{
+			"file.txt":                          scaffold.ActionSkipExisting,
+			"file.txt" + scaffold.SidecarSuffix: scaffold.ActionOverwrite,
+		}},
+	}
+	for _, test := range tests {
+		outdir := t.TempDir()
+		files := map[string]string{"file.txt": "existing\n"}
+		if test.sidecar {
+			files["file.txt"+scaffold.SidecarSuffix] = "edited sidecar\n"
+		}
+		testWriteFiles(t, outdir, files)
+		lockfile, err := config.LoadLockfile(filepath.Join(outdir, config.LockfileFilename))
+		if err != nil {
+			t.Fatal(err)
+		}
+		plan, err := scaffold.PlanGenerate(lockfile, scaffoldDir, "", outdir, scaffold.Options{
+			Conflict:       test.policy,
+			NonInteractive: true,
+		})
+		lockfile.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		actions := testActions(plan)
+		assert.Equal(t, len(actions), len(test.expected))
+		for name, kind := range test.expected {
+			if actions[name] != kind {
+				t.Errorf("%s (sidecar %v): expected %s to be %s, got %s", test.policy, test.sidecar, name, kind, actions[name])
+			}
+		}
+	}
+}
+
+func TestConflictPoliciesUpgrade(t *testing.T) {
+	for _, test := range []struct {
+		policy   scaffold.ConflictPolicy
+		expected scaffold.ActionKind
+	}{
+		{"", scaffold.ActionConflict},
+		{scaffold.ConflictOurs, scaffold.ActionSkipModified},
+		{scaffold.ConflictTheirs, scaffold.ActionOverwrite},
+		{scaffold.ConflictBackup, scaffold.ActionSkipModified},
+		{scaffold.ConflictDiff, scaffold.ActionConflict},
+	} {
+		scaffoldDir := t.TempDir()
+		testWriteFiles(t, scaffoldDir, map[string]string{
+			config.ManifestFilename: testManifest,
+			"file.txt":              "scaffold\n",
+		})
+		outdir, lockfile := testGenerate(t, scaffoldDir)
+
+		// Both the scaffold and the generated file change, and the scaffold
+		// isn't in git, so the changes can't be merged
+		testWriteFiles(t, scaffoldDir, map[string]string{"file.txt": "upgraded\n"})
+		if err := os.WriteFile(filepath.Join(outdir, "file.txt"), []byte("modified\n"), 0666); err != nil {
+			t.Fatal(err)
+		}
+		plan, err := scaffold.PlanUpgrade(lockfile, scaffoldDir, "", outdir, scaffold.Options{
+			Conflict:       test.policy,
+			NonInteractive: true,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		actions := testActions(plan)
+		if actions["file.txt"] != test.expected {
+			t.Errorf("%s: expected file.txt to be %s, got %s", test.policy, test.expected, actions["file.txt"])
+		}
+		if test.policy == scaffold.ConflictBackup {
+			assert.Equal(t, actions["file.txt"+scaffold.SidecarSuffix], scaffold.ActionCreate)
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package scaffold
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/olafal0/rescaffold/config"
+	"github.com/olafal0/rescaffold/expr"
+	"github.com/olafal0/rescaffold/set"
+)
+
+// DeriveVars computes the value of every derived var in the manifest (those
+// with an expr or template) from the values of other vars, and adds them to
+// varValues. Derived vars may depend on each other, and are computed in
+// dependency order.
+func DeriveVars(manifest *config.Manifest, varValues map[string]string) error {
+	order, err := derivedVarOrder(manifest)
+	if err != nil {
+		return err
+	}
+	for _, varName := range order {
+		varOptions := manifest.Vars[varName]
+		var value string
+		if varOptions.Expr != "" {
+			e, err := expr.Parse(varOptions.Expr, manifest.Config.ModifierDelim)
+			if err != nil {
+				return fmt.Errorf("derived var %s: %w", varName, err)
+			}
+			value, err = e.Eval(varValues, Modifiers)
+			if err != nil {
+				return fmt.Errorf("derived var %s: %w", varName, err)
+			}
+		} else {
+			replacer, err := RegexpLoopReplacer(manifest, varValues)
+			if err != nil {
+				return err
+			}
+			value = replacer(varOptions.Template)
+		}
+
+		parsed, err := varOptions.Parse(value)
+		if err != nil {
+			return fmt.Errorf("derived var %s has an invalid value: %w", varName, err)
+		}
+		varValues[varName] = parsed
+	}
+	return nil
+}
+
+// resolveVars loads the values of a scaffold's vars with LoadVars, then
+// computes its derived vars. Derived values are always recomputed, rather than
+// taken from the lockfile, so they follow changes to the vars they depend on.
+func resolveVars(manifest *config.Manifest, lockfileVars map[string]string, opts Options) (map[string]string, error) {
+	varValues, err := LoadVars(manifest.Vars, lockfileVars, opts)
+	if err != nil {
+		return nil, err
+	}
+	if err := DeriveVars(manifest, varValues); err != nil {
+		return nil, err
+	}
+	return varValues, nil
+}
+
+// derivedVarDeps returns the names of the vars that a derived var depends on.
+func derivedVarDeps(manifest *config.Manifest, varName string) ([]string, error) {
+	varOptions := manifest.Vars[varName]
+	if varOptions.Expr != "" {
+		e, err := expr.Parse(varOptions.Expr, manifest.Config.ModifierDelim)
+		if err != nil {
+			return nil, fmt.Errorf("derived var %s: %w", varName, err)
+		}
+		return e.Vars(), nil
+	}
+	return referencedVars(manifest, set.Keys(manifest.Vars), varOptions.Template)
+}
+
+// derivedVarOrder returns the names of all derived vars, ordered so that each
+// var comes after every derived var it depends on. An error is returned if
+// derived vars depend on each other in a cycle, or on vars that aren't
+// declared.
+func derivedVarOrder(manifest *config.Manifest) ([]string, error) {
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := map[string]int{}
+	order := []string{}
+
+	var visit func(varName string, path []string) error
+	visit = func(varName string, path []string) error {
+		switch state[varName] {
+		case visited:
+			return nil
+		case visiting:
+			return fmt.Errorf("derived vars depend on each other in a cycle: %s", strings.Join(append(path, varName), " -> "))
+		}
+		state[varName] = visiting
+		deps, err := derivedVarDeps(manifest, varName)
+		if err != nil {
+			return err
+		}
+		for _, dep := range deps {
+			depOptions, ok := manifest.Vars[dep]
+			if !ok {
+				return fmt.Errorf("derived var %s references undeclared var %s", varName, dep)
+			}
+			if !depOptions.IsDerived() {
+				continue
+			}
+			if err := visit(dep, append(path, varName)); err != nil {
+				return err
+			}
+		}
+		state[varName] = visited
+		order = append(order, varName)
+		return nil
+	}
+
+	varNames := set.Keys(manifest.Vars)
+	sort.Strings(varNames)
+	for _, varName := range varNames {
+		if !manifest.Vars[varName].IsDerived() {
+			continue
+		}
+		if err := visit(varName, nil); err != nil {
+			return nil, err
+		}
+	}
+	return order, nil
+}
@@ -0,0 +1,147 @@
+package scaffold
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+
+	"github.com/olafal0/rescaffold/config"
+	"github.com/olafal0/rescaffold/diff"
+	"github.com/olafal0/rescaffold/set"
+)
+
+// DiffOptions control what Diff compares a scaffold's latest version with.
+type DiffOptions struct {
+	// Ref is the git ref the scaffold is rendered from. If empty, the ref
+	// recorded in the lockfile (if any) is used.
+	Ref string
+	// FromLocked compares with the scaffold rendered from the commit recorded
+	// in the lockfile, instead of the files in the output directory
+	FromLocked bool
+	// Offline loads git sources from the cache instead of fetching them
+	Offline bool
+}
+
+// Diff renders the latest version of a scaffold with the vars recorded in the
+// lockfile, and writes a unified diff from the files in outdir to the rendered
+// files to w. Files the scaffold no longer generates, but which are tracked in
+// the lockfile, are shown as removed. Binary files are only reported as
+// differing.
+func Diff(w io.Writer, lockfile *config.Lockfile, source, outdir string, opts DiffOptions) error {
+	lockedScaffold, ok := lockfile.Scaffolds[source]
+	if !ok {
+		return fmt.Errorf("%s is not in the lockfile", source)
+	}
+	ref := opts.Ref
+	if ref == "" {
+		ref = lockedScaffold.Ref
+	}
+	rendered, err := renderScaffold(source, ref, lockedScaffold.Vars, outdir, opts.Offline)
+	if err != nil {
+		return err
+	}
+
+	var previous map[string][]byte
+	if opts.FromLocked {
+		if lockedScaffold.Commit == "" {
+			return fmt.Errorf("%s has no locked commit to compare with", source)
+		}
+		previous, err = renderScaffold(source, lockedScaffold.Commit, lockedScaffold.Vars, outdir, opts.Offline)
+		if err != nil {
+			return err
+		}
+	} else {
+		// Compare with the files on disk that the scaffold generates or tracks
+		previous = map[string][]byte{}
+		paths := set.Keys(rendered)
+		for _, lockedFile := range lockedScaffold.Files {
+			paths = append(paths, lockedFile.Path)
+		}
+		for _, outpath := range paths {
+			current, err := readExisting(outpath)
+			if os.IsNotExist(err) {
+				continue
+			}
+			if err != nil {
+				return fmt.Errorf("error reading existing file: %w", err)
+			}
+			previous[outpath] = current
+		}
+	}
+
+	paths := set.New(set.Keys(rendered)...)
+	for outpath := range previous {
+		paths.Add(outpath)
+	}
+	sortedPaths := set.Keys(paths)
+	sort.Strings(sortedPaths)
+	for _, outpath := range sortedPaths {
+		before, hadBefore := previous[outpath]
+		after, hasAfter := rendered[outpath]
+		fromName, toName := outpath, outpath
+		if !hadBefore {
+			fromName = "/dev/null"
+		}
+		if !hasAfter {
+			toName = "/dev/null"
+		}
+
+		var text string
+		if isBinary(before) || isBinary(after) {
+			if string(before) != string(after) {
+				text = fmt.Sprintf("Binary files %s and %s differ\n", fromName, toName)
+			}
+		} else {
+			text = diff.Unified(fromName, toName, before, after, 3)
+			if text == "" && hadBefore != hasAfter {
+				// Empty files are added or removed without any changed lines
+				text = fmt.Sprintf("--- %s\n+++ %s\n", fromName, toName)
+			}
+		}
+		if _, err := io.WriteString(w, text); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// renderScaffold loads a scaffold at ref and renders all of its files with the
+// given vars, and returns the rendered content of each file by output path.
+// Vars without values take their defaults.
+func renderScaffold(source, ref string, vars map[string]string, outdir string, offline bool) (map[string][]byte, error) {
+	scaf, err := LoadScaffold(source, ref, offline)
+	if err != nil {
+		return nil, err
+	}
+	defer scaf.Cleanup()
+
+	varValues, err := resolveVars(scaf.Manifest, vars, Options{NonInteractive: true})
+	if err != nil {
+		return nil, err
+	}
+	tmpl, err := NewTemplate(scaf.Manifest, varValues)
+	if err != nil {
+		return nil, err
+	}
+	scaffoldFiles, err := scaf.IncludedFiles(varValues)
+	if err != nil {
+		return nil, err
+	}
+	targets, err := tmpl.targets(scaffoldFiles)
+	if err != nil {
+		return nil, err
+	}
+
+	rendered := make(map[string][]byte, len(targets))
+	for _, target := range targets {
+		outpath := path.Join(outdir, target.tmpl.Replace(target.RelativePath))
+		content, _, err := renderFile(target.ScaffoldFile, target.tmpl)
+		if err != nil {
+			return nil, err
+		}
+		rendered[outpath] = content
+	}
+	return rendered, nil
+}
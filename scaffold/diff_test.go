@@ -0,0 +1,53 @@
+package scaffold_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/olafal0/rescaffold/assert"
+	"github.com/olafal0/rescaffold/config"
+	"github.com/olafal0/rescaffold/scaffold"
+)
+
+func TestDiff(t *testing.T) {
+	scaffoldDir := t.TempDir()
+	testWriteFiles(t, scaffoldDir, map[string]string{
+		config.ManifestFilename: testManifest,
+		"main.txt":              "name: _name_\nline 2\n",
+		"removed.txt":           "removed\n",
+	})
+	outdir, lockfile := testGenerate(t, scaffoldDir)
+
+	// Nothing has changed yet
+	buf := &strings.Builder{}
+	err := scaffold.Diff(buf, lockfile, scaffoldDir, outdir, scaffold.DiffOptions{})
+	assert.Equal(t, err, nil)
+	assert.Equal(t, buf.String(), "")
+
+	// Change the scaffold, and modify a generated file
+	testWriteFiles(t, scaffoldDir, map[string]string{
+		"main.txt":  "name: _name_\nline 2 changed\n",
+		"added.txt": "added _name_\n",
+	})
+	if err := os.Remove(filepath.Join(scaffoldDir, "removed.txt")); err != nil {
+		t.Fatal(err)
+	}
+	testWriteFiles(t, outdir, map[string]string{"main.txt": "name: mine\nline 2\n"})
+
+	buf.Reset()
+	err = scaffold.Diff(buf, lockfile, scaffoldDir, outdir, scaffold.DiffOptions{})
+	assert.Equal(t, err, nil)
+	added := filepath.Join(outdir, "added.txt")
+	main := filepath.Join(outdir, "main.txt")
+	removed := filepath.Join(outdir, "removed.txt")
+	assert.Equal(t, buf.String(), ""+
+		"--- /dev/null\n+++ "+added+"\n@@ -0,0 +1 @@\n+added app\n"+
+		"--- "+main+"\n+++ "+main+"\n@@ -1,2 +1,2 @@\n-name: mine\n-line 2\n+name: app\n+line 2 changed\n"+
+		"--- "+removed+"\n+++ /dev/null\n@@ -1 +0,0 @@\n-removed\n")
+
+	// Local directories have no locked commit to compare with
+	err = scaffold.Diff(buf, lockfile, scaffoldDir, outdir, scaffold.DiffOptions{FromLocked: true})
+	assert.StrContains(t, err.Error(), "no locked commit")
+}
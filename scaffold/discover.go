@@ -0,0 +1,60 @@
+package scaffold
+
+import (
+	"fmt"
+	"os"
+	"path"
+
+	"github.com/olafal0/rescaffold/config"
+)
+
+// DiscoveredScaffold is a scaffold found within a source.
+type DiscoveredScaffold struct {
+	// Source is the reference to the scaffold, including its subdirectory
+	// within the source, if any
+	Source   string
+	Manifest *config.Manifest
+}
+
+// Discover returns all scaffolds within a git URL or local directory, or within
+// a subdirectory of it if source has the form "source//subdir". ref and offline
+// are as for LoadScaffold.
+func Discover(source, ref string, offline bool) ([]DiscoveredScaffold, error) {
+	repo, subdir := splitSubdir(source)
+	if err := checkSubdir(subdir); err != nil {
+		return nil, err
+	}
+	root := repo
+	if isGitURL(repo) || ref != "" {
+		dir, _, cleanup, err := fetchGit(repo, ref, offline)
+		if err != nil {
+			return nil, err
+		}
+		defer cleanup()
+		root = dir
+	}
+
+	searchDir := path.Join(root, subdir)
+	scaffoldDirs, err := findScaffolds(searchDir, "", 0, nil)
+	if err != nil {
+		return nil, err
+	}
+	discovered := make([]DiscoveredScaffold, 0, len(scaffoldDirs))
+	for _, dir := range scaffoldDirs {
+		manifestPath := path.Join(searchDir, dir, config.ManifestFilename)
+		f, err := os.Open(manifestPath)
+		if err != nil {
+			return nil, err
+		}
+		manifest, err := config.ParseManifest(f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("could not parse %s: %w", path.Join(subdir, dir, config.ManifestFilename), err)
+		}
+		discovered = append(discovered, DiscoveredScaffold{
+			Source:   JoinSource(repo, path.Join(subdir, dir)),
+			Manifest: manifest,
+		})
+	}
+	return discovered, nil
+}
@@ -2,124 +2,290 @@ package scaffold
 
 import (
 	"bufio"
+	"bytes"
 	"crypto/sha256"
 	"encoding/hex"
 	"fmt"
 	"io"
+	"io/fs"
 	"os"
 	"path"
+	"strings"
 
 	"github.com/olafal0/rescaffold/config"
 )
 
-func Generate(lockfile *config.Lockfile, scaffoldSource, outdir string) error {
-	scaf, err := LoadScaffold(scaffoldSource)
+// PlanGenerate plans the generation of a scaffold's files into outdir. Files
+// that already exist are left in place, unless they differ from the scaffold's
+// version and the conflict policy chooses to overwrite them.
+func PlanGenerate(lockfile *config.Lockfile, scaffoldSource, ref, outdir string, opts Options) (*Plan, error) {
+	scaf, err := LoadScaffold(scaffoldSource, ref, opts.Offline)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	defer scaf.Cleanup()
 
-	lockedScaffold := lockfile.GetScaffold(scaffoldSource, scaf.Manifest)
+	lockedScaffold := plannedScaffold(lockfile, scaffoldSource)
+	plan := &Plan{
+		Source:      scaffoldSource,
+		FromCommit:  lockedScaffold.Commit,
+		ToCommit:    scaf.Commit,
+		PostInstall: scaf.Manifest.Meta.PostInstall,
+		outdir:      outdir,
+		locked:      lockedScaffold,
+	}
+	lockedScaffold.Ref = ref
+	lockedScaffold.Commit = scaf.Commit
 
 	// Find all vars in the manifest
 	// If any do not have values in the lockfile, prompt the user for them
-	varValues, err := LoadVarsInteractive(scaf.Manifest.Vars, lockedScaffold.Vars)
+	varValues, err := resolveVars(scaf.Manifest, lockedScaffold.Vars, opts)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	lockedScaffold.Vars = varValues
 
-	replacer, err := RegexpLoopReplacer(scaf.Manifest, varValues)
+	tmpl, err := NewTemplate(scaf.Manifest, varValues)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	for _, scaffoldFile := range scaf.Files {
-		outFilename := replacer(scaffoldFile.RelativePath)
+	scaffoldFiles, err := scaf.IncludedFiles(varValues)
+	if err != nil {
+		return nil, err
+	}
+	targets, err := tmpl.targets(scaffoldFiles)
+	if err != nil {
+		return nil, err
+	}
+	for _, target := range targets {
+		outFilename := target.tmpl.Replace(target.RelativePath)
 		outpath := path.Join(outdir, outFilename)
 
 		// Get file info from lockfile (may be nil)
 		lockedFile := lockedScaffold.GetFile(outpath)
 
 		// Check if file exists at destination
-		existingOutfile, err := os.Open(outpath)
-		if err != nil && !os.IsNotExist(err) {
-			return fmt.Errorf("error checking if file exists: %w", err)
+		checksum, exists, err := existingChecksum(outpath)
+		if err != nil {
+			return nil, err
 		}
 
-		// If file exists, check that its contents are what we expect (matching checksum)
-		if existingOutfile != nil {
-			if lockedFile == nil {
-				fmt.Printf("file already exists but is not in lockfile, skipping: %s\n", outpath)
+		// If file exists and matches expected value, continue
+		if exists && lockedFile != nil && lockedFile.Checksum == checksum {
+			plan.add(ActionUnchanged, outpath, "")
+			continue
+		}
+
+		content, newChecksum, err := renderFile(target.ScaffoldFile, target.tmpl)
+		if err != nil {
+			return nil, err
+		}
+
+		// If file exists, but doesn't have the contents we expect, there is a
+		// conflict unless it already matches the scaffold's version
+		if exists {
+			if newChecksum == checksum {
+				plan.add(ActionUnchanged, outpath, "")
+				trackFile(lockedScaffold, outpath, newChecksum, target.Mode)
+				continue
+			}
+			if lockedFile != nil && lockedFile.Checksum == newChecksum {
+				// The file was modified, but the scaffold's version is the same as
+				// when it was generated, so the modifications can be kept
+				plan.add(ActionSkipModified, outpath, "")
 				continue
 			}
 
-			checksum, err := hashFile(existingOutfile)
+			reason := "file has been modified"
+			if lockedFile == nil {
+				reason = "file already exists but is not in lockfile"
+			}
+			current, err := readExisting(outpath)
 			if err != nil {
-				return err
+				return nil, fmt.Errorf("error reading existing file: %w", err)
 			}
-
-			if lockedFile.Checksum != checksum {
-				return fmt.Errorf("file has been modified: %s", outpath)
+			err = opts.resolveConflict(plan, lockedScaffold, conflict{
+				path:     outpath,
+				reason:   reason,
+				tracked:  lockedFile != nil,
+				current:  current,
+				rendered: content,
+				checksum: newChecksum,
+				mode:     target.Mode,
+			})
+			if err != nil {
+				return nil, err
 			}
-
-			// Output file exists and matches expected value, continue
 			continue
 		}
 
-		// File does not exist; create directories in path and create file for writing
-		err = os.MkdirAll(path.Dir(outpath), 0755)
-		if err != nil {
-			return fmt.Errorf("error creating subdirectories: %w", err)
+		plan.add(ActionCreate, outpath, "").setContent(content, target.Mode)
+
+		// Update lockfile with new file information for each new file
+		trackFile(lockedScaffold, outpath, newChecksum, target.Mode)
+	}
+
+	return plan, nil
+}
+
+// binarySniffLen is the number of bytes at the start of a file that are
+// checked for NUL bytes to decide whether the file is binary.
+const binarySniffLen = 8000
+
+// isBinary reports whether content looks like the content of a binary file.
+func isBinary(content []byte) bool {
+	if len(content) > binarySniffLen {
+		content = content[:binarySniffLen]
+	}
+	return bytes.IndexByte(content, 0) != -1
+}
+
+// ApplyTemplate renders a template file from src to dst, and returns the
+// checksum of the bytes written. Line endings, including whether the file ends
+// with a newline, are preserved, and lines may be of any length. Binary files
+// are copied verbatim.
+func ApplyTemplate(src io.Reader, dst io.Writer, tmpl *Template) (checksum string, err error) {
+	reader := bufio.NewReaderSize(src, binarySniffLen)
+	head, err := reader.Peek(binarySniffLen)
+	if err != nil && err != io.EOF {
+		return "", err
+	}
+	if isBinary(head) {
+		return copyVerbatim(reader, dst)
+	}
+
+	hasher := sha256.New()
+	out := io.MultiWriter(dst, hasher)
+	parser := &templateParser{tmpl: tmpl}
+	for lineNum := 1; ; lineNum++ {
+		line, readErr := reader.ReadString('\n')
+		if readErr != nil && readErr != io.EOF {
+			return "", readErr
 		}
-		outfile, err := os.Create(outpath)
-		if err != nil {
-			return fmt.Errorf("error creating file: %w", err)
+		if line == "" {
+			break
 		}
 
-		// Stream file from input -> template replacement -> output
-		sourceFile, err := os.Open(scaffoldFile.FullPath)
-		if err != nil {
-			return fmt.Errorf("error opening source file: %w", err)
+		ending := ""
+		if strings.HasSuffix(line, "\n") {
+			line, ending = strings.TrimSuffix(line, "\n"), "\n"
+			if strings.HasSuffix(line, "\r") {
+				line, ending = strings.TrimSuffix(line, "\r"), "\r\n"
+			}
 		}
-		defer sourceFile.Close()
-
-		newChecksum, err := ApplyTemplate(sourceFile, outfile, replacer)
+		node, err := parser.feed(line, ending, lineNum)
 		if err != nil {
-			return fmt.Errorf("error applying template: %w", err)
+			return "", err
 		}
+		if node != nil {
+			if err := node.render(tmpl, out); err != nil {
+				return "", err
+			}
+		}
+		if readErr == io.EOF {
+			break
+		}
+	}
+	if err := parser.finish(); err != nil {
+		return "", err
+	}
 
-		// Update lockfile with new file information for each new file
-		lockedScaffold.SetFile(outpath, newChecksum)
-		if err := lockfile.WriteUpdated(); err != nil {
-			return fmt.Errorf("error writing updated lockfile: %w", err)
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
+
+// copyVerbatim copies src to dst unchanged, and returns the checksum of the
+// bytes written.
+func copyVerbatim(src io.Reader, dst io.Writer) (checksum string, err error) {
+	hasher := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(dst, hasher), src); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
+
+// renderFile applies the template to a scaffold file and returns the result
+// in memory, along with its checksum. For symlinks, the result is the link
+// target.
+func renderFile(scaffoldFile ScaffoldFile, tmpl *Template) ([]byte, string, error) {
+	if scaffoldFile.Mode&fs.ModeSymlink != 0 {
+		target := tmpl.Replace(scaffoldFile.LinkTarget)
+		if err := checkLinkTarget(tmpl.Replace(scaffoldFile.RelativePath), target); err != nil {
+			return nil, "", err
 		}
+		return []byte(target), checksumBytes([]byte(target)), nil
+	}
+
+	sourceFile, err := os.Open(scaffoldFile.FullPath)
+	if err != nil {
+		return nil, "", fmt.Errorf("error opening source file: %w", err)
 	}
+	defer sourceFile.Close()
 
-	if scaf.Manifest.Meta.PostInstall != "" {
-		fmt.Println("Post-install instructions:")
-		fmt.Println(scaf.Manifest.Meta.PostInstall)
+	buf := &bytes.Buffer{}
+	var checksum string
+	if tmpl.isVerbatim(scaffoldFile.RelativePath) {
+		checksum, err = copyVerbatim(sourceFile, buf)
+	} else {
+		checksum, err = ApplyTemplate(sourceFile, buf, tmpl)
 	}
-	return nil
+	if err != nil {
+		return nil, "", fmt.Errorf("error applying template to %s: %w", scaffoldFile.RelativePath, err)
+	}
+	return buf.Bytes(), checksum, nil
 }
 
-func ApplyTemplate(src io.Reader, dst io.Writer, replacer func(string) string) (checksum string, err error) {
-	hasher := sha256.New()
-	srcScan := bufio.NewScanner(src)
-	for srcScan.Scan() {
-		line := srcScan.Text()
-		replacedLine := append([]byte(replacer(line)), '\n')
-		_, err := dst.Write(replacedLine)
-		if err != nil {
-			return "", err
+// existingChecksum returns the checksum of the file at outpath, and whether it
+// exists. The checksum of a symlink is the checksum of its target path.
+func existingChecksum(outpath string) (checksum string, exists bool, err error) {
+	info, err := os.Lstat(outpath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", false, nil
 		}
-		_, err = hasher.Write(replacedLine)
+		return "", false, fmt.Errorf("error checking if file exists: %w", err)
+	}
+	if info.Mode()&fs.ModeSymlink != 0 {
+		target, err := os.Readlink(outpath)
 		if err != nil {
-			return "", err
+			return "", false, err
 		}
+		return checksumBytes([]byte(target)), true, nil
 	}
 
-	return hex.EncodeToString(hasher.Sum(nil)), nil
+	f, err := os.Open(outpath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", false, nil
+		}
+		return "", false, fmt.Errorf("error checking if file exists: %w", err)
+	}
+	defer f.Close()
+
+	checksum, err = hashFile(f)
+	if err != nil {
+		return "", false, err
+	}
+	return checksum, true, nil
+}
+
+// readExisting returns the content of the file at outpath, or the target path
+// if it is a symlink.
+func readExisting(outpath string) ([]byte, error) {
+	info, err := os.Lstat(outpath)
+	if err != nil {
+		return nil, err
+	}
+	if info.Mode()&fs.ModeSymlink != 0 {
+		target, err := os.Readlink(outpath)
+		return []byte(target), err
+	}
+	return os.ReadFile(outpath)
+}
+
+func checksumBytes(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
 }
 
 // hashFile returns the sha256 hash of the contents of the given file. hashFile
@@ -0,0 +1,60 @@
+package scaffold
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// runGit runs git with the given arguments in dir, and returns its output.
+// git is run directly rather than through a shell, so arguments are never
+// interpreted. If git fails, the error includes its error output.
+func runGit(dir string, args ...string) (string, error) {
+	subcommand := args[0]
+	// Cached repositories may be pruned at any time, so don't let fetches start
+	// background maintenance that could write to them after they've been removed
+	args = append([]string{"-c", "gc.auto=0", "-c", "maintenance.auto=false"}, args...)
+	if dir != "" {
+		args = append([]string{"-C", dir}, args...)
+	}
+	cmd := exec.Command("git", args...)
+	// Fail instead of prompting for credentials on the terminal
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+	stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return "", fmt.Errorf("git %s: %w: %s", subcommand, err, msg)
+		}
+		return "", fmt.Errorf("git %s: %w", subcommand, err)
+	}
+	return stdout.String(), nil
+}
+
+// fetchCommit fetches a single commit into a shallow repository, so that files
+// can be read from it.
+func fetchCommit(dir, commit string) error {
+	if strings.HasPrefix(commit, "-") {
+		return fmt.Errorf("invalid commit %q", commit)
+	}
+	if _, err := runGit(dir, "cat-file", "-e", commit+"^{commit}"); err == nil {
+		// The commit has already been fetched
+		return nil
+	}
+	_, err := runGit(dir, "fetch", "--quiet", "--depth", "1", "origin", commit)
+	return err
+}
+
+// fetchHistory fetches up to depth commits of the history leading to commit into
+// a shallow repository, so that it can be searched.
+func fetchHistory(dir, commit string, depth int) error {
+	if strings.HasPrefix(commit, "-") {
+		return fmt.Errorf("invalid commit %q", commit)
+	}
+	_, err := runGit(dir, "fetch", "--quiet", "--depth", strconv.Itoa(depth), "origin", commit)
+	return err
+}
@@ -0,0 +1,166 @@
+package scaffold
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/olafal0/rescaffold/assert"
+	"github.com/olafal0/rescaffold/config"
+)
+
+// testGit runs git in dir, failing the test on error.
+func testGit(t *testing.T, dir string, args ...string) string {
+	t.Helper()
+	args = append([]string{"-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)
+	out, err := runGit(dir, args...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.TrimSpace(out)
+}
+
+// testBareRepo creates a bare repository with a scaffold in a subdirectory
+// with spaces in its name, at two commits. The first commit is tagged v1.
+func testBareRepo(t *testing.T) (bare, first, second string) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	root := t.TempDir()
+	work := filepath.Join(root, "work dir")
+	scaffoldDir := filepath.Join(work, "my scaffold")
+	if err := os.MkdirAll(scaffoldDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	manifest := "[meta]\ntitle = \"Test\"\n\n[config]\nopen_delim = \"_\"\nclose_delim = \"_\"\n"
+	if err := os.WriteFile(filepath.Join(scaffoldDir, config.ManifestFilename), []byte(manifest), 0666); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(scaffoldDir, "file.txt"), []byte("first\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	testGit(t, root, "init", "--quiet", work)
+	testGit(t, work, "add", ".")
+	testGit(t, work, "commit", "--quiet", "-m", "first")
+	testGit(t, work, "tag", "v1")
+	first = testGit(t, work, "rev-parse", "HEAD")
+
+	if err := os.WriteFile(filepath.Join(scaffoldDir, "file.txt"), []byte("second\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	testGit(t, work, "commit", "--quiet", "-am", "second")
+	second = testGit(t, work, "rev-parse", "HEAD")
+
+	bare = filepath.Join(root, "bare repo.git")
+	testGit(t, root, "clone", "--quiet", "--bare", work, bare)
+	return bare, first, second
+}
+
+func TestCheckoutGit(t *testing.T) {
+	bare, first, second := testBareRepo(t)
+	branch := testGit(t, bare, "symbolic-ref", "--short", "HEAD")
+	root := t.TempDir()
+
+	for ref, expected := range map[string]string{
+		"":             second,
+		"v1":           first,
+		first:          first,
+		first[:7]:      first,
+		branch:         second,
+		"refs/tags/v1": first,
+	} {
+		for _, offline := range []bool{false, true} {
+			dir, commit, err := checkoutGit(root, bare, ref, offline)
+			if err != nil {
+				t.Errorf("checking out %q (offline: %v): %v", ref, offline, err)
+				continue
+			}
+			assert.Equal(t, commit, expected)
+			content, err := os.ReadFile(filepath.Join(dir, "my scaffold", "file.txt"))
+			assert.Equal(t, err, nil)
+			if expected == first {
+				assert.Equal(t, string(content), "first\n")
+			} else {
+				assert.Equal(t, string(content), "second\n")
+			}
+		}
+	}
+
+	if _, _, err := checkoutGit(root, bare, "no-such-ref", false); err == nil {
+		t.Error("expected error checking out a missing ref")
+	}
+	if _, _, err := checkoutGit(root, bare, "v2", true); err == nil {
+		t.Error("expected error checking out an uncached ref offline")
+	}
+	if _, _, err := checkoutGit(t.TempDir(), bare, "", true); err == nil {
+		t.Error("expected error checking out an uncached source offline")
+	}
+	if _, _, err := checkoutGit(root, "--upload-pack=touch /tmp/pwned", "", false); err == nil {
+		t.Error("expected error for a source that looks like a flag")
+	}
+}
+
+func TestLoadFromGit(t *testing.T) {
+	bare, first, second := testBareRepo(t)
+	cacheHome := t.TempDir()
+	t.Setenv("XDG_CACHE_HOME", cacheHome)
+	source := "file://" + bare
+
+	scaf, err := loadFromGit(source, "", "my scaffold", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer scaf.Cleanup()
+	assert.Equal(t, scaf.Commit, second)
+	assert.Equal(t, scaf.Manifest.Meta.Title, "Test")
+	assert.Equal(t, len(scaf.Files), 1)
+
+	// Files can be read from the locked commit, even though it wasn't fetched
+	if err := fetchCommit(scaf.dir, first); err != nil {
+		t.Fatal(err)
+	}
+	content, err := runGit(scaf.dir, "show", first+":./file.txt")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, content, "first\n")
+
+	// The scaffold is cached, so it can be loaded again offline
+	scaf.Cleanup()
+	offlineScaf, err := loadFromGit(source, "", "my scaffold", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, offlineScaf.Commit, second)
+
+	root, err := CacheDir()
+	assert.Equal(t, err, nil)
+	cached, err := ListCache(root)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, len(cached), 1)
+	assert.Equal(t, cached[0].Source, source)
+	assert.Equal(t, len(cached[0].Commits), 1)
+	assert.Equal(t, cached[0].Commits[0].Commit, second)
+
+	removed, err := PruneCache(root, time.Hour)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, removed, 0)
+	removed, err = PruneCache(root, 0)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, removed, 1)
+	cached, err = ListCache(root)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, len(cached), 0)
+
+	// Local repositories are never cached
+	localScaf, err := loadFromGit(bare, "v1", "my scaffold", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer localScaf.Cleanup()
+	assert.Equal(t, localScaf.Commit, first)
+	cached, err = ListCache(root)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, len(cached), 0)
+}
@@ -0,0 +1,62 @@
+package scaffold
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+)
+
+// maxHistorySearch limits how many past revisions of a file are considered when
+// looking for the version that a locked file was generated from.
+const maxHistorySearch = 100
+
+// hasHistory reports whether the scaffold is stored in a git repository, whose
+// history can be searched by findPreviousRender.
+func hasHistory(scaf *Scaffold) bool {
+	if scaf.dir == "" {
+		return false
+	}
+	_, err := runGit(scaf.dir, "rev-parse", "--git-dir")
+	return err == nil
+}
+
+// findPreviousRender finds the revision of a scaffold file that, rendered with
+// tmpl, produces content matching checksum. The file is first looked up at
+// lockedCommit (if not empty), then searched for in the file's git history. It
+// returns the rendered content, or nil if no matching revision was found (for
+// example, if the scaffold is not stored in a git repository).
+func findPreviousRender(scaf *Scaffold, scaffoldFile ScaffoldFile, lockedCommit string, tmpl *Template, checksum string) []byte {
+	if scaf.dir == "" {
+		return nil
+	}
+	relPath := strings.TrimPrefix(scaffoldFile.RelativePath, "/")
+	commits := []string{}
+	if lockedCommit != "" {
+		commits = append(commits, lockedCommit)
+		if scaf.shallow && fetchCommit(scaf.dir, lockedCommit) != nil {
+			// Clones only include the checked out commit, so the locked commit must
+			// be fetched. If it can't be, for example because history was
+			// rewritten, the file's history is fetched to search instead.
+			fetchHistory(scaf.dir, scaf.Commit, maxHistorySearch)
+		}
+	}
+	out, err := runGit(scaf.dir, "log", "--format=%H", "-n", strconv.Itoa(maxHistorySearch), "--", relPath)
+	if err == nil {
+		commits = append(commits, strings.Fields(out)...)
+	}
+	for _, commit := range commits {
+		content, err := runGit(scaf.dir, "show", commit+":./"+relPath)
+		if err != nil {
+			continue
+		}
+		buf := &bytes.Buffer{}
+		renderedChecksum, err := ApplyTemplate(strings.NewReader(content), buf, tmpl)
+		if err != nil {
+			continue
+		}
+		if renderedChecksum == checksum {
+			return buf.Bytes()
+		}
+	}
+	return nil
+}
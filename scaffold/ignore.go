@@ -0,0 +1,90 @@
+package scaffold
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/olafal0/rescaffold/config"
+	"github.com/olafal0/rescaffold/glob"
+)
+
+// IgnoreFilename is the name of files listing patterns of files that are not
+// part of a scaffold, with the same semantics as .gitignore files. Like
+// .gitignore files, they can be placed in any directory, and their patterns are
+// relative to that directory.
+const IgnoreFilename = ".rescaffoldignore"
+
+// ignoreRule applies the patterns of an ignore file, or of a manifest's exclude
+// list, to the files within a directory.
+type ignoreRule struct {
+	// dir is the directory the patterns are relative to
+	dir    string
+	ignore *glob.Ignore
+}
+
+// ignoreRules holds the rules that apply to a directory, outermost first.
+type ignoreRules []ignoreRule
+
+// withDir returns the rules extended with those defined in dir, which is
+// relative to root: the patterns of dir's ignore file, and if dir contains a
+// manifest, its exclude list.
+func (rules ignoreRules) withDir(root, dir string) (ignoreRules, error) {
+	ignore := &glob.Ignore{}
+	manifestFilename := path.Join(root, dir, config.ManifestFilename)
+	if f, err := os.Open(manifestFilename); err == nil {
+		manifest, err := config.ParseManifest(f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("error parsing %s: %w", manifestFilename, err)
+		}
+		if manifest.Config != nil {
+			for _, pattern := range manifest.Config.Exclude {
+				if err := ignore.Add(pattern); err != nil {
+					return nil, fmt.Errorf("invalid exclude pattern %q in %s: %w", pattern, manifestFilename, err)
+				}
+			}
+		}
+	}
+
+	// Patterns in the ignore file come after the manifest's, so they take
+	// precedence
+	ignoreFilename := path.Join(root, dir, IgnoreFilename)
+	if f, err := os.Open(ignoreFilename); err == nil {
+		err := ignore.Read(f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("error parsing %s: %w", ignoreFilename, err)
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	extended := make(ignoreRules, len(rules), len(rules)+1)
+	copy(extended, rules)
+	return append(extended, ignoreRule{dir: dir, ignore: ignore}), nil
+}
+
+// isIgnored reports whether a file or directory, given by its path relative to
+// the root, is excluded by the rules. The innermost rule that matches the path
+// decides.
+func (rules ignoreRules) isIgnored(relPath string, isDir bool) bool {
+	if IgnoreFiles.Contains(path.Base(relPath)) {
+		return true
+	}
+	for i := len(rules) - 1; i >= 0; i-- {
+		rule := rules[i]
+		rel := relPath
+		if rule.dir != "" {
+			if !strings.HasPrefix(relPath, rule.dir+"/") {
+				continue
+			}
+			rel = strings.TrimPrefix(relPath, rule.dir+"/")
+		}
+		if ignored, ok := rule.ignore.Match(rel, isDir); ok {
+			return ignored
+		}
+	}
+	return false
+}
@@ -0,0 +1,60 @@
+package scaffold_test
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/olafal0/rescaffold/assert"
+	"github.com/olafal0/rescaffold/config"
+	"github.com/olafal0/rescaffold/scaffold"
+)
+
+func TestLoadFromDirIgnore(t *testing.T) {
+	scaffoldDir := t.TempDir()
+	testWriteFiles(t, scaffoldDir, map[string]string{
+		config.ManifestFilename:          "[meta]\ntitle = \"Test\"\n\n[config]\nopen_delim = \"_\"\nclose_delim = \"_\"\nexclude = [\"README.md\", \"docs/\"]\n",
+		scaffold.IgnoreFilename:          "*.log\n!keep.log\nnode_modules/\n",
+		"README.md":                      "excluded by the manifest\n",
+		"docs/guide.md":                  "excluded by the manifest\n",
+		"app.log":                        "ignored\n",
+		"keep.log":                       "negated\n",
+		"node_modules/pkg/a.js":          "ignored directory\n",
+		"src/node_modules":               "a file, not a directory\n",
+		"src/" + scaffold.IgnoreFilename: "generated.go\n!debug.log\n",
+		"src/main.go":                    "package main\n",
+		"src/generated.go":               "ignored by the nested ignore file\n",
+		"src/debug.log":                  "negated by the nested ignore file\n",
+		"src/trace.log":                  "ignored\n",
+	})
+
+	scaf, err := scaffold.LoadFromDir(scaffoldDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"/keep.log", "/src/debug.log", "/src/main.go", "/src/node_modules"}
+	files := []string{}
+	for _, file := range scaf.Files {
+		files = append(files, file.RelativePath)
+	}
+	sort.Strings(files)
+	assert.Equal(t, strings.Join(files, " "), strings.Join(expected, " "))
+
+	// Ignored files aren't generated either
+	outdir, _ := testGenerate(t, scaffoldDir)
+	generated := []string{}
+	err = filepath.Walk(outdir, func(filename string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() || info.Name() == config.LockfileFilename {
+			return err
+		}
+		generated = append(generated, filepath.ToSlash(strings.TrimPrefix(filename, outdir)))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(generated)
+	assert.Equal(t, strings.Join(generated, " "), strings.Join(expected, " "))
+}
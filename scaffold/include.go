@@ -0,0 +1,54 @@
+package scaffold
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/olafal0/rescaffold/expr"
+	"github.com/olafal0/rescaffold/glob"
+)
+
+// IncludedFiles returns the scaffold files that should be generated given the
+// values of the scaffold's vars. A file is excluded if it matches the path of
+// any [[files]] entry in the manifest whose when condition is false.
+func (s *Scaffold) IncludedFiles(varValues map[string]string) ([]ScaffoldFile, error) {
+	excludePatterns := []string{}
+	for _, manifestFile := range s.Manifest.Files {
+		if err := glob.Validate(manifestFile.Path); err != nil {
+			return nil, fmt.Errorf("invalid files path %q: %w", manifestFile.Path, err)
+		}
+		if manifestFile.When == "" {
+			continue
+		}
+		condition, err := expr.Parse(manifestFile.When, s.Manifest.Config.ModifierDelim)
+		if err != nil {
+			return nil, fmt.Errorf("files %s: %w", manifestFile.Path, err)
+		}
+		include, err := condition.EvalBool(varValues, Modifiers)
+		if err != nil {
+			return nil, fmt.Errorf("files %s: %w", manifestFile.Path, err)
+		}
+		if !include {
+			excludePatterns = append(excludePatterns, manifestFile.Path)
+		}
+	}
+	if len(excludePatterns) == 0 {
+		return s.Files, nil
+	}
+
+	included := make([]ScaffoldFile, 0, len(s.Files))
+	for _, scaffoldFile := range s.Files {
+		relativePath := strings.TrimPrefix(scaffoldFile.RelativePath, "/")
+		excluded := false
+		for _, pattern := range excludePatterns {
+			if glob.MatchPath(pattern, relativePath) {
+				excluded = true
+				break
+			}
+		}
+		if !excluded {
+			included = append(included, scaffoldFile)
+		}
+	}
+	return included, nil
+}
@@ -0,0 +1,143 @@
+package scaffold_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/olafal0/rescaffold/assert"
+	"github.com/olafal0/rescaffold/config"
+	"github.com/olafal0/rescaffold/scaffold"
+)
+
+const testConditionalManifest = testManifest + `
+[vars.use_docker]
+type = "bool"
+default = "true"
+
+[[files]]
+path = "Dockerfile"
+when = "use_docker == true"
+
+[[files]]
+path = "compose.yml"
+when = "use_docker == true"
+`
+
+// testConditionalScaffold creates a scaffold whose Docker files are only
+// generated if use_docker is true, and generates it into a new directory with
+// the given value of use_docker.
+func testConditionalScaffold(t *testing.T, useDocker string) (scaffoldDir, outdir string, lockfile *config.Lockfile) {
+	t.Helper()
+	scaffoldDir = t.TempDir()
+	testWriteFiles(t, scaffoldDir, map[string]string{
+		config.ManifestFilename: testConditionalManifest,
+		"main.go":               "package main\n",
+		"Dockerfile":            "FROM scratch\n",
+		"compose.yml":           "services: {}\n",
+	})
+	outdir = t.TempDir()
+	lockfile, err := config.LoadLockfile(filepath.Join(outdir, config.LockfileFilename))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { lockfile.Close() })
+	plan, err := scaffold.PlanGenerate(lockfile, scaffoldDir, "", outdir, scaffold.Options{
+		Vars:           map[string]string{"use_docker": useDocker},
+		NonInteractive: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := scaffold.Apply(lockfile, plan); err != nil {
+		t.Fatal(err)
+	}
+	return scaffoldDir, outdir, lockfile
+}
+
+// testExists reports whether the file at path exists.
+func testExists(t *testing.T, path string) bool {
+	t.Helper()
+	_, err := os.Stat(path)
+	if err != nil && !os.IsNotExist(err) {
+		t.Fatal(err)
+	}
+	return err == nil
+}
+
+func TestConditionalFilesGenerate(t *testing.T) {
+	scaffoldDir, outdir, lockfile := testConditionalScaffold(t, "false")
+	assert.Equal(t, testExists(t, filepath.Join(outdir, "main.go")), true)
+	assert.Equal(t, testExists(t, filepath.Join(outdir, "Dockerfile")), false)
+	assert.Equal(t, testExists(t, filepath.Join(outdir, "compose.yml")), false)
+	locked := lockfile.Scaffolds[scaffoldDir]
+	assert.Equal(t, len(locked.Files), 1)
+	assert.Equal(t, locked.Vars["use_docker"], "false")
+
+	scaffoldDir, outdir, lockfile = testConditionalScaffold(t, "true")
+	assert.Equal(t, testExists(t, filepath.Join(outdir, "Dockerfile")), true)
+	assert.Equal(t, testExists(t, filepath.Join(outdir, "compose.yml")), true)
+	assert.Equal(t, len(lockfile.Scaffolds[scaffoldDir].Files), 3)
+}
+
+func TestConditionalFilesUpgrade(t *testing.T) {
+	scaffoldDir, outdir, lockfile := testConditionalScaffold(t, "true")
+	testWriteFiles(t, outdir, map[string]string{"compose.yml": "services: {app: {}}\n"})
+
+	// Once the condition is false, the unmodified file is deleted, and the
+	// modified one is left in place but no longer tracked
+	plan, err := scaffold.PlanUpgrade(lockfile, scaffoldDir, "", outdir, scaffold.Options{
+		Vars:           map[string]string{"use_docker": "false"},
+		NonInteractive: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	actions := testActions(plan)
+	assert.Equal(t, actions["Dockerfile"], scaffold.ActionDelete)
+	assert.Equal(t, actions["compose.yml"], scaffold.ActionUntrack)
+	if err := scaffold.Apply(lockfile, plan); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, testExists(t, filepath.Join(outdir, "Dockerfile")), false)
+	assert.Equal(t, testExists(t, filepath.Join(outdir, "compose.yml")), true)
+	locked := lockfile.Scaffolds[scaffoldDir]
+	assert.Equal(t, len(locked.Files), 1)
+	assert.Equal(t, locked.Files[0].Path, filepath.Join(outdir, "main.go"))
+	assert.Equal(t, locked.Vars["use_docker"], "false")
+}
+
+func TestConditionalFilesRemove(t *testing.T) {
+	// Files that were never generated are left alone
+	scaffoldDir, outdir, lockfile := testConditionalScaffold(t, "false")
+	testWriteFiles(t, outdir, map[string]string{"Dockerfile": "FROM mine\n"})
+	plan, err := scaffold.PlanRemove(lockfile, scaffoldDir, outdir, scaffold.Options{NonInteractive: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	actions := testActions(plan)
+	assert.Equal(t, len(actions), 1)
+	assert.Equal(t, actions["main.go"], scaffold.ActionDelete)
+
+	// Tracked files are removed even if their condition no longer holds, unless
+	// they've been modified
+	scaffoldDir, outdir, lockfile = testConditionalScaffold(t, "true")
+	testWriteFiles(t, outdir, map[string]string{"compose.yml": "services: {app: {}}\n"})
+	lockfile.Scaffolds[scaffoldDir].Vars["use_docker"] = "false"
+	plan, err = scaffold.PlanRemove(lockfile, scaffoldDir, outdir, scaffold.Options{NonInteractive: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	actions = testActions(plan)
+	assert.Equal(t, len(actions), 3)
+	assert.Equal(t, actions["main.go"], scaffold.ActionDelete)
+	assert.Equal(t, actions["Dockerfile"], scaffold.ActionDelete)
+	assert.Equal(t, actions["compose.yml"], scaffold.ActionSkipModified)
+	if err := scaffold.Apply(lockfile, plan); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, testExists(t, filepath.Join(outdir, "Dockerfile")), false)
+	assert.Equal(t, testExists(t, filepath.Join(outdir, "compose.yml")), true)
+	_, tracked := lockfile.Scaffolds[scaffoldDir]
+	assert.Equal(t, tracked, false)
+}
@@ -0,0 +1,444 @@
+package scaffold
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/olafal0/rescaffold/config"
+	"github.com/olafal0/rescaffold/expr"
+	"github.com/olafal0/rescaffold/set"
+)
+
+// LintProblem is a mistake in a scaffold, found by Lint.
+type LintProblem struct {
+	// Path is the path of the file containing the problem, within the
+	// directory given to Lint
+	Path string
+	// Line is the line of the file the problem is on, or 0 if it isn't on a
+	// particular line, e.g. because it's in the file's path
+	Line    int
+	Message string
+}
+
+func (p LintProblem) String() string {
+	if p.Line == 0 {
+		return fmt.Sprintf("%s: %s", p.Path, p.Message)
+	}
+	return fmt.Sprintf("%s:%d: %s", p.Path, p.Line, p.Message)
+}
+
+// Lint checks the scaffold in dir for mistakes that would otherwise only be
+// found when generating it: invalid var declarations, references to undeclared
+// vars in file contents, paths, and conditions, declared vars that are never
+// used, and delimiters that can't be matched. Problems are returned sorted by
+// path and line; an error is only returned if the scaffold can't be loaded at
+// all.
+func Lint(dir string) ([]LintProblem, error) {
+	scaf, err := LoadFromDir(dir)
+	if err != nil {
+		var varErr *config.VarError
+		if !errors.As(err, &varErr) {
+			return nil, err
+		}
+		// Invalid vars prevent the scaffold from loading, so they're the only
+		// problem that can be reported
+		problem, ok := varProblem(dir, varErr)
+		if !ok {
+			return nil, err
+		}
+		return []LintProblem{problem}, nil
+	}
+	manifestPath := path.Join(scaf.dir, config.ManifestFilename)
+	manifestData, err := os.ReadFile(manifestPath)
+	if err != nil {
+		return nil, err
+	}
+	manifest := scaf.Manifest
+	if manifest.Config == nil {
+		manifest.Config = &config.ManifestConfig{}
+	}
+
+	l := &linter{
+		manifest:      manifest,
+		manifestPath:  manifestPath,
+		manifestLines: strings.Split(string(manifestData), "\n"),
+		declared:      set.New[string](),
+		used:          set.New[string](),
+	}
+	for varName, varOptions := range manifest.Vars {
+		l.declared.Add(varName)
+		if varOptions.Item != "" {
+			l.declared.Add(varOptions.Item)
+		}
+	}
+
+	// Staging directories are in the project a scaffold was generated into,
+	// which may be the scaffold itself during development
+	stale, err := StaleStagingDirs(scaf.dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, dir := range stale {
+		l.report(dir, 0, "left behind by an interrupted rescaffold run; delete it, or it becomes part of the scaffold")
+	}
+	l.lintVars()
+	l.lintFileConditions()
+	if manifest.Config.OpenDelim == "" && manifest.Config.CloseDelim == "" {
+		// Without delimiters, every occurrence of a var's name is a reference
+		// to it, so references can't be checked
+		l.report(l.manifestPath, l.manifestLine("config", ""),
+			"open_delim and close_delim are both empty, so var names are replaced wherever they appear")
+	} else {
+		if err := l.lintFiles(scaf); err != nil {
+			return nil, err
+		}
+		l.lintUnused()
+	}
+
+	sort.SliceStable(l.problems, func(i, j int) bool {
+		if l.problems[i].Path != l.problems[j].Path {
+			return l.problems[i].Path < l.problems[j].Path
+		}
+		return l.problems[i].Line < l.problems[j].Line
+	})
+	return l.problems, nil
+}
+
+// varProblem returns the problem for an invalid var declaration in the
+// manifest in dir, on the line of the key that's invalid. It reports false if
+// dir doesn't contain the manifest.
+func varProblem(dir string, varErr *config.VarError) (LintProblem, bool) {
+	manifestPath := path.Join(dir, config.ManifestFilename)
+	manifestData, err := os.ReadFile(manifestPath)
+	if err != nil {
+		return LintProblem{}, false
+	}
+	l := &linter{manifestLines: strings.Split(string(manifestData), "\n")}
+	section := "vars." + varErr.Var
+	line := l.manifestLine(section, varErr.Key)
+	if line == 0 {
+		line = l.manifestLine(section, "")
+	}
+	return LintProblem{Path: manifestPath, Line: line, Message: varErr.Error()}, true
+}
+
+// linter collects the problems found in a scaffold.
+type linter struct {
+	manifest      *config.Manifest
+	manifestPath  string
+	manifestLines []string
+	// declared holds the names of the manifest's vars, and of the items of its
+	// list vars
+	declared set.Set[string]
+	// used holds the names of declared vars that are referred to anywhere
+	used     set.Set[string]
+	problems []LintProblem
+}
+
+func (l *linter) report(filePath string, line int, format string, args ...any) {
+	l.problems = append(l.problems, LintProblem{
+		Path:    filePath,
+		Line:    line,
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+// use records that vars are referred to, including the list vars whose items
+// they are.
+func (l *linter) use(varNames ...string) {
+	for _, varName := range varNames {
+		l.used.Add(varName)
+		for listName, varOptions := range l.manifest.Vars {
+			if varOptions.Item == varName {
+				l.used.Add(listName)
+			}
+		}
+	}
+}
+
+// lintVars checks the references of derived vars. Defaults don't need to be
+// checked, since manifests with invalid defaults can't be loaded, and are
+// reported by varProblem instead.
+func (l *linter) lintVars() {
+	for _, varName := range sortedVarNames(l.manifest) {
+		varOptions := l.manifest.Vars[varName]
+		section := "vars." + varName
+		if varOptions.Expr != "" {
+			l.lintExpr(l.manifestPath, l.manifestLine(section, "expr"), varOptions.Expr, nil)
+		}
+		if varOptions.Template != "" {
+			l.lintText(l.manifestPath, l.manifestLine(section, "template"), varOptions.Template, nil)
+		}
+	}
+}
+
+// lintFileConditions checks the when conditions of the manifest's [[files]]
+// entries.
+func (l *linter) lintFileConditions() {
+	for _, manifestFile := range l.manifest.Files {
+		if manifestFile.When == "" {
+			continue
+		}
+		l.lintExpr(l.manifestPath, l.manifestValueLine("when", manifestFile.When), manifestFile.When, nil)
+	}
+}
+
+// lintExpr checks that an expression parses, and only refers to declared vars
+// or the given bound names.
+func (l *linter) lintExpr(filePath string, line int, src string, bound []string) {
+	e, err := expr.Parse(src, l.manifest.Config.ModifierDelim)
+	if err != nil {
+		l.report(filePath, line, "%v", err)
+		return
+	}
+	for _, varName := range e.Vars() {
+		if l.declared.Contains(varName) {
+			l.use(varName)
+		} else if !contains(bound, varName) {
+			l.report(filePath, line, "undeclared var %s in %q", varName, src)
+		}
+	}
+}
+
+// lintFiles checks the paths and contents of every file in the scaffold.
+func (l *linter) lintFiles(scaf *Scaffold) error {
+	tmpl := &Template{manifest: l.manifest}
+	for _, scaffoldFile := range scaf.Files {
+		relativePath := strings.TrimPrefix(scaffoldFile.RelativePath, "/")
+		filePath := path.Join(scaf.dir, relativePath)
+		l.lintText(filePath, 0, relativePath, nil)
+		if scaffoldFile.Mode&os.ModeSymlink != 0 {
+			l.lintText(filePath, 0, scaffoldFile.LinkTarget, nil)
+			continue
+		}
+		if tmpl.isVerbatim(relativePath) {
+			continue
+		}
+		content, err := os.ReadFile(scaffoldFile.FullPath)
+		if err != nil {
+			return err
+		}
+		if isBinary(content) {
+			continue
+		}
+		l.lintContent(tmpl, filePath, string(content))
+	}
+	return nil
+}
+
+// lintContent checks the lines and block directives of a template file.
+func (l *linter) lintContent(tmpl *Template, filePath, content string) {
+	type openBlock struct {
+		keyword string
+		line    int
+		// item is the name bound by a for block
+		item string
+	}
+	open := []openBlock{}
+	for i, line := range strings.Split(content, "\n") {
+		lineNum := i + 1
+		line = strings.TrimSuffix(line, "\r")
+		bound := []string{}
+		for _, block := range open {
+			if block.item != "" {
+				bound = append(bound, block.item)
+			}
+		}
+
+		keyword, arg, ok := tmpl.directive(line)
+		if !ok {
+			l.lintText(filePath, lineNum, line, bound)
+			continue
+		}
+		switch keyword {
+		case "if":
+			l.lintExpr(filePath, lineNum, arg, bound)
+			open = append(open, openBlock{keyword: keyword, line: lineNum})
+		case "else", "else if":
+			if len(open) == 0 || open[len(open)-1].keyword != "if" {
+				l.report(filePath, lineNum, "%s without if", keyword)
+			}
+			if keyword == "else if" {
+				l.lintExpr(filePath, lineNum, arg, bound)
+			}
+		case "for":
+			item, list, ok := strings.Cut(arg, " in ")
+			item, list = strings.TrimSpace(item), strings.TrimSpace(list)
+			if !ok || item == "" || list == "" || strings.ContainsAny(item+list, " \t") {
+				l.report(filePath, lineNum, "for must be given as \"for item in list\"")
+			} else if varOptions, ok := l.manifest.Vars[list]; !ok {
+				l.report(filePath, lineNum, "undeclared var %s", list)
+			} else {
+				l.use(list)
+				if varOptions.Type != config.VarTypeList {
+					l.report(filePath, lineNum, "for loops over var %s, which is not a list", list)
+				}
+			}
+			open = append(open, openBlock{keyword: keyword, line: lineNum, item: item})
+		default: // "end"
+			if len(open) == 0 {
+				l.report(filePath, lineNum, "end without if or for")
+				continue
+			}
+			open = open[:len(open)-1]
+		}
+	}
+	for _, block := range open {
+		l.report(filePath, block.line, "%s without end", block.keyword)
+	}
+}
+
+// identifierPattern matches anything that could be the name of a var.
+const identifierPattern = `[A-Za-z][A-Za-z0-9_]*`
+
+// lintText checks the var references in a line of text or a path, which may
+// refer to declared vars and the given bound names.
+func (l *linter) lintText(filePath string, line int, s string, bound []string) {
+	known := append(set.Keys(l.declared), bound...)
+	matcher, err := varRefMatcher(l.manifest, known)
+	if err != nil {
+		l.report(filePath, line, "%v", err)
+		return
+	}
+	for _, submatch := range matcher.FindAllStringSubmatch(s, -1) {
+		if l.declared.Contains(submatch[1]) {
+			l.use(submatch[1])
+		}
+	}
+	// Blank out the references to known vars, so that what's left can be
+	// searched for references to any other name
+	s = matcher.ReplaceAllStringFunc(s, func(ref string) string {
+		return strings.Repeat(" ", len(ref))
+	})
+
+	candidates, err := l.candidateMatcher()
+	if err != nil {
+		l.report(filePath, line, "%v", err)
+		return
+	}
+	reported := set.New[string]()
+	for _, loc := range candidates.FindAllStringSubmatchIndex(s, -1) {
+		varName := s[loc[2]:loc[3]]
+		if reported.Contains(varName) || l.isEmbedded(s, loc[0], loc[1]) {
+			continue
+		}
+		reported.Add(varName)
+		l.report(filePath, line, "undeclared var %s", varName)
+	}
+}
+
+// candidateMatcher returns a regexp matching references to vars of any name,
+// with the same submatches as varRefMatcher.
+func (l *linter) candidateMatcher() (*regexp.Regexp, error) {
+	cfg := l.manifest.Config
+	return regexp.Compile(fmt.Sprintf(`%[1]s(%[2]s)((?:%[3]s(?:%[4]s))*)%[5]s`,
+		regexp.QuoteMeta(cfg.OpenDelim),
+		identifierPattern,
+		regexp.QuoteMeta(cfg.ModifierDelim),
+		strings.Join(set.Keys(Modifiers), "|"),
+		regexp.QuoteMeta(cfg.CloseDelim),
+	))
+}
+
+// isEmbedded reports whether the possible reference at s[start:end] is part of
+// a longer word, such as "_var_" in "my_var_name" with "_" delimiters. Such
+// matches are usually ordinary text, so they aren't reported.
+func (l *linter) isEmbedded(s string, start, end int) bool {
+	openDelim, closeDelim := l.manifest.Config.OpenDelim, l.manifest.Config.CloseDelim
+	if first, _ := utf8.DecodeRuneInString(openDelim); openDelim != "" && isWordRune(first) {
+		if before, _ := utf8.DecodeLastRuneInString(s[:start]); start > 0 && isWordRune(before) {
+			return true
+		}
+	}
+	if last, _ := utf8.DecodeLastRuneInString(closeDelim); closeDelim != "" && isWordRune(last) {
+		if after, _ := utf8.DecodeRuneInString(s[end:]); end < len(s) && isWordRune(after) {
+			return true
+		}
+	}
+	return false
+}
+
+func isWordRune(r rune) bool {
+	return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+// lintUnused reports declared vars that are never referred to.
+func (l *linter) lintUnused() {
+	for _, varName := range sortedVarNames(l.manifest) {
+		if !l.used.Contains(varName) {
+			l.report(l.manifestPath, l.manifestLine("vars."+varName, ""), "var %s is declared but never used", varName)
+		}
+	}
+}
+
+// manifestLine returns the line number of key within the given table of the
+// manifest, or of the table's header if key is empty. It returns 0 if the line
+// can't be found, e.g. because the manifest uses inline tables.
+func (l *linter) manifestLine(table, key string) int {
+	current := ""
+	for i, line := range l.manifestLines {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "[") {
+			current = normalizeTableName(line)
+			if current == table && key == "" {
+				return i + 1
+			}
+			continue
+		}
+		if current == table && key != "" {
+			name, _, ok := strings.Cut(line, "=")
+			if ok && strings.Trim(strings.TrimSpace(name), `"'`) == key {
+				return i + 1
+			}
+		}
+	}
+	return 0
+}
+
+// manifestValueLine returns the line number of the first assignment of value
+// to key anywhere in the manifest, or 0 if it can't be found.
+func (l *linter) manifestValueLine(key, value string) int {
+	for i, line := range l.manifestLines {
+		name, rest, ok := strings.Cut(line, "=")
+		if ok && strings.TrimSpace(name) == key && strings.Contains(rest, value) {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+// normalizeTableName returns the name of the table in a header line such as
+// `[vars."name"]` or `[[files]]`, without brackets, quotes, or spaces.
+func normalizeTableName(header string) string {
+	if i := strings.Index(header, "#"); i != -1 {
+		header = header[:i]
+	}
+	header = strings.Trim(strings.TrimSpace(header), "[]")
+	parts := strings.Split(header, ".")
+	for i, part := range parts {
+		parts[i] = strings.Trim(strings.TrimSpace(part), `"'`)
+	}
+	return strings.Join(parts, ".")
+}
+
+func sortedVarNames(manifest *config.Manifest) []string {
+	names := set.Keys(manifest.Vars)
+	sort.Strings(names)
+	return names
+}
+
+func contains(names []string, name string) bool {
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,119 @@
+package scaffold_test
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/olafal0/rescaffold/assert"
+	"github.com/olafal0/rescaffold/config"
+	"github.com/olafal0/rescaffold/scaffold"
+)
+
+func TestLint(t *testing.T) {
+	scaffoldDir := t.TempDir()
+	manifest := `[meta]
+title = "Test"
+
+[config]
+open_delim = "x_"
+close_delim = "_"
+modifier_delim = "|"
+directives = true
+
+[vars.name]
+default = "app"
+
+[vars.db]
+type = "enum"
+enum_values = ["postgres", "sqlite"]
+default = "sqlite"
+
+[vars.services]
+type = "list"
+item = "svc"
+
+[vars.unused]
+
+[[files]]
+path = "docker/**"
+when = "use_docker == true"
+`
+	testWriteFiles(t, scaffoldDir, map[string]string{
+		config.ManifestFilename: manifest,
+		"x_svc_.go":             "package x_svc_\n",
+		"x_nmae_.txt":           "x_name_\n",
+		"main.go": "package x_name|lowercase_\n" +
+			"// max_value_ is not a reference\n" +
+			"x_if db == \"postgres\"_\n" +
+			"x_Name_ x_projct_name|uppercase_ x_projct_name|uppercase_\n" +
+			"x_for s in services_\n" +
+			"x_s_\n" +
+			"x_end_\n" +
+			"x_end_\n" +
+			"x_for s in name_\n" +
+			"x_end_\n",
+	})
+
+	problems, err := scaffold.Lint(scaffoldDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifestPath := filepath.Join(scaffoldDir, config.ManifestFilename)
+	expected := []string{
+		manifestPath + ":22: var unused is declared but never used",
+		manifestPath + ":26: undeclared var use_docker in \"use_docker == true\"",
+		filepath.Join(scaffoldDir, "main.go") + ":4: undeclared var Name",
+		filepath.Join(scaffoldDir, "main.go") + ":4: undeclared var projct_name",
+		filepath.Join(scaffoldDir, "main.go") + ":9: for loops over var name, which is not a list",
+		filepath.Join(scaffoldDir, "x_nmae_.txt") + ": undeclared var nmae",
+	}
+	assert.Equal(t, len(problems), len(expected))
+	for i, problem := range problems {
+		if i < len(expected) {
+			assert.Equal(t, problem.String(), expected[i])
+		}
+	}
+
+	// Invalid vars prevent the scaffold from loading, and are reported on the
+	// line of the invalid key
+	invalid := strings.Replace(manifest, `default = "sqlite"`, `default = "mysql"`, 1)
+	testWriteFiles(t, scaffoldDir, map[string]string{config.ManifestFilename: invalid})
+	problems, err = scaffold.Lint(scaffoldDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, len(problems), 1)
+	assert.Equal(t, problems[0].String(), manifestPath+`:16: default of var db is invalid: "mysql" is not one of postgres, sqlite`)
+}
+
+func TestLintEmptyDelimiters(t *testing.T) {
+	scaffoldDir := t.TempDir()
+	testWriteFiles(t, scaffoldDir, map[string]string{
+		config.ManifestFilename: "[meta]\ntitle = \"Test\"\n\n[config]\nopen_delim = \"\"\n\n[vars.name]\n",
+		"name.txt":              "name\n",
+	})
+	problems, err := scaffold.Lint(scaffoldDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, len(problems), 1)
+	assert.Equal(t, problems[0].Line, 4)
+	assert.StrContains(t, problems[0].Message, "open_delim and close_delim are both empty")
+}
+
+func TestLintStagingDir(t *testing.T) {
+	scaffoldDir := t.TempDir()
+	testWriteFiles(t, scaffoldDir, map[string]string{
+		config.ManifestFilename:       testManifest,
+		"_name_.txt":                  "_name_\n",
+		".rescaffold-tx-123/staged-0": "left behind\n",
+	})
+	problems, err := scaffold.Lint(scaffoldDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, len(problems), 1)
+	assert.Equal(t, problems[0].Path, filepath.Join(scaffoldDir, ".rescaffold-tx-123"))
+	assert.StrContains(t, problems[0].Message, "interrupted rescaffold run")
+}
@@ -0,0 +1,268 @@
+package scaffold
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"text/tabwriter"
+
+	"github.com/olafal0/rescaffold/config"
+)
+
+// ActionKind describes what happens to a single path when a plan is applied.
+type ActionKind int
+
+const (
+	// ActionCreate writes a new file
+	ActionCreate ActionKind = iota
+	// ActionOverwrite replaces an unmodified file with new content
+	ActionOverwrite
+	// ActionMerge replaces a modified file with the result of a three-way merge
+	ActionMerge
+	// ActionUnchanged leaves a file that is already up to date in place
+	ActionUnchanged
+	// ActionSkipModified leaves a file that has been modified in place
+	ActionSkipModified
+	// ActionSkipExisting leaves a file that exists but is not tracked in place
+	ActionSkipExisting
+	// ActionDelete deletes an unmodified file and stops tracking it
+	ActionDelete
+	// ActionUntrack stops tracking a file without touching it
+	ActionUntrack
+	// ActionConflict is a file that cannot be handled safely; applying a plan
+	// containing a conflict fails
+	ActionConflict
+)
+
+var actionKindNames = map[ActionKind]string{
+	ActionCreate:       "create",
+	ActionOverwrite:    "overwrite",
+	ActionMerge:        "merge",
+	ActionUnchanged:    "unchanged",
+	ActionSkipModified: "skip-modified",
+	ActionSkipExisting: "skip-existing",
+	ActionDelete:       "delete",
+	ActionUntrack:      "untrack",
+	ActionConflict:     "conflict",
+}
+
+func (k ActionKind) String() string {
+	return actionKindNames[k]
+}
+
+// modifies reports whether an action changes the file at its path
+func (k ActionKind) modifies() bool {
+	return k == ActionCreate || k == ActionOverwrite || k == ActionMerge || k == ActionDelete
+}
+
+// Action is a single planned change to a path in the output directory.
+type Action struct {
+	Kind ActionKind
+	Path string
+	// Detail is a human-readable explanation of the action, if needed
+	Detail string
+
+	// content is the data written to Path for create, overwrite, and merge
+	// actions, or the link target if mode is a symlink
+	content []byte
+	mode    fs.FileMode
+}
+
+// setContent sets the data written to the action's path, and its mode.
+func (a *Action) setContent(content []byte, mode fs.FileMode) {
+	a.content = content
+	a.mode = mode
+}
+
+// Plan is the set of changes that generating, upgrading, or removing a scaffold
+// will make. Plans are computed without modifying the output directory or the
+// lockfile, and can be inspected before being applied with Apply.
+type Plan struct {
+	Source string
+	// FromCommit and ToCommit are the git commits of the scaffold before and
+	// after the plan is applied, if known
+	FromCommit string
+	ToCommit   string
+	Actions    []*Action
+	// PostInstall holds instructions to print once the plan has been applied
+	PostInstall string
+
+	outdir string
+	// locked is the lockfile state of the scaffold after the plan is applied, or
+	// nil if the scaffold is removed from the lockfile
+	locked *config.LockfileScaffold
+}
+
+func (p *Plan) add(kind ActionKind, path, detail string) *Action {
+	action := &Action{
+		Kind:   kind,
+		Path:   path,
+		Detail: detail,
+	}
+	p.Actions = append(p.Actions, action)
+	return action
+}
+
+// WriteTable writes a human-readable table of the plan's actions to w.
+func (p *Plan) WriteTable(w io.Writer) error {
+	header := p.Source
+	switch {
+	case p.ToCommit == "":
+	case p.FromCommit == "" || p.FromCommit == p.ToCommit:
+		header += " @ " + shortCommit(p.ToCommit)
+	default:
+		header += fmt.Sprintf(" (%s -> %s)", shortCommit(p.FromCommit), shortCommit(p.ToCommit))
+	}
+	if _, err := fmt.Fprintln(w, header); err != nil {
+		return err
+	}
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	for _, action := range p.Actions {
+		if action.Detail == "" {
+			fmt.Fprintf(tw, "  %s\t%s\n", action.Kind, action.Path)
+		} else {
+			fmt.Fprintf(tw, "  %s\t%s\t%s\n", action.Kind, action.Path, action.Detail)
+		}
+	}
+	return tw.Flush()
+}
+
+// Apply writes the changes in one or more plans to the output directory and
+// updates the lockfile. All changes are staged first and committed together; if
+// any of them fails, the output directory and lockfile are restored to their
+// original state. Nothing is changed if any plan contains conflicts.
+func Apply(lockfile *config.Lockfile, plans ...*Plan) (err error) {
+	if len(plans) == 0 {
+		return nil
+	}
+	writtenBy := map[string]string{}
+	for _, plan := range plans {
+		for _, action := range plan.Actions {
+			if action.Kind == ActionConflict {
+				return fmt.Errorf("%s: %s", action.Detail, action.Path)
+			}
+			if !action.Kind.modifies() {
+				continue
+			}
+			if other, ok := writtenBy[action.Path]; ok && other != plan.Source {
+				return fmt.Errorf("%s is changed by both %s and %s", action.Path, other, plan.Source)
+			}
+			writtenBy[action.Path] = plan.Source
+		}
+	}
+
+	tx, err := newTransaction(plans[0].outdir)
+	if err != nil {
+		return err
+	}
+	defer tx.cleanup()
+
+	deleted := false
+	for _, plan := range plans {
+		for _, action := range plan.Actions {
+			switch action.Kind {
+			case ActionCreate, ActionOverwrite, ActionMerge:
+				if err := tx.write(action.Path, action.content, action.mode); err != nil {
+					return err
+				}
+			case ActionDelete:
+				tx.remove(action.Path)
+				deleted = true
+			}
+		}
+	}
+
+	// Update the lockfile in memory, restoring it if the changes can't be
+	// committed
+	previous := make(map[string]*config.LockfileScaffold, len(plans))
+	for _, plan := range plans {
+		previous[plan.Source] = lockfile.Scaffolds[plan.Source]
+		if plan.locked != nil {
+			lockfile.Scaffolds[plan.Source] = plan.locked
+		} else {
+			lockfile.RemoveScaffold(plan.Source)
+		}
+	}
+	defer func() {
+		if err == nil {
+			return
+		}
+		for source, lockedScaffold := range previous {
+			if lockedScaffold != nil {
+				lockfile.Scaffolds[source] = lockedScaffold
+			} else {
+				lockfile.RemoveScaffold(source)
+			}
+		}
+	}()
+
+	// Fail before changing any files if the lockfile can't be encoded, or
+	// another process has changed it
+	if _, err := lockfile.Encode(); err != nil {
+		return err
+	}
+	if err := lockfile.CheckUnchanged(); err != nil {
+		return err
+	}
+
+	if err := tx.commit(); err != nil {
+		return fmt.Errorf("changes were rolled back: %w", err)
+	}
+	// The lockfile is replaced atomically once all files are in place, so that
+	// it never records files that weren't written
+	if len(lockfile.Scaffolds) > 0 {
+		err = lockfile.WriteUpdated()
+	} else {
+		err = lockfile.Remove()
+	}
+	if err != nil {
+		tx.rollback()
+		return fmt.Errorf("could not update lockfile, changes were rolled back: %w", err)
+	}
+	// Remove the staging directory now, so that it doesn't interfere with
+	// removing empty directories
+	tx.cleanup()
+
+	if deleted {
+		// Walk outdir and remove any empty directories
+		if _, err := removeEmptyDirectories("", plans[0].outdir); err != nil {
+			return err
+		}
+	}
+
+	for _, plan := range plans {
+		if plan.FromCommit != "" && plan.ToCommit != "" {
+			if plan.FromCommit == plan.ToCommit {
+				fmt.Printf("%s: already at %s\n", plan.Source, shortCommit(plan.ToCommit))
+			} else {
+				fmt.Printf("%s: %s -> %s\n", plan.Source, shortCommit(plan.FromCommit), shortCommit(plan.ToCommit))
+			}
+		}
+		for _, action := range plan.Actions {
+			if action.Kind != ActionCreate && action.Kind != ActionOverwrite && action.Kind != ActionUnchanged && action.Detail != "" {
+				fmt.Printf("%s: %s\n", action.Detail, action.Path)
+			}
+		}
+		if plan.PostInstall != "" {
+			fmt.Println("Post-install instructions:")
+			fmt.Println(plan.PostInstall)
+		}
+	}
+	return nil
+}
+
+// plannedScaffold returns a copy of the lockfile information for a scaffold,
+// which can be modified while planning without affecting the lockfile.
+func plannedScaffold(lockfile *config.Lockfile, source string) *config.LockfileScaffold {
+	if lockedScaffold, ok := lockfile.Scaffolds[source]; ok {
+		return lockedScaffold.Clone()
+	}
+	lockedScaffold := config.NewLockfileScaffold(source)
+	lockedScaffold.Source, lockedScaffold.Subdir = splitSubdir(source)
+	return lockedScaffold
+}
+
+// trackFile records a file written from the scaffold in the lockfile.
+func trackFile(lockedScaffold *config.LockfileScaffold, outpath, checksum string, mode fs.FileMode) {
+	lockedScaffold.SetFile(outpath, checksum).SetMode(mode)
+}
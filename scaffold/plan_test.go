@@ -0,0 +1,44 @@
+package scaffold_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/olafal0/rescaffold/assert"
+	"github.com/olafal0/rescaffold/scaffold"
+)
+
+func TestPlanWriteTable(t *testing.T) {
+	actions := []*scaffold.Action{
+		{Kind: scaffold.ActionCreate, Path: "main.go"},
+		{Kind: scaffold.ActionMerge, Path: "README.md", Detail: "merged local modifications"},
+		{Kind: scaffold.ActionDelete, Path: "old.go"},
+	}
+	for _, test := range []struct {
+		plan     scaffold.Plan
+		expected string
+	}{
+		{
+			scaffold.Plan{Source: "./scaffold", Actions: actions},
+			"./scaffold\n" +
+				"  create  main.go\n" +
+				"  merge   README.md  merged local modifications\n" +
+				"  delete  old.go\n",
+		},
+		{
+			scaffold.Plan{Source: "https://example.com/scaffold", ToCommit: "abc1234567", Actions: actions[:1]},
+			"https://example.com/scaffold @ abc1234\n" +
+				"  create  main.go\n",
+		},
+		{
+			scaffold.Plan{Source: "https://example.com/scaffold", FromCommit: "abc1234567", ToCommit: "def5678901"},
+			"https://example.com/scaffold (abc1234 -> def5678)\n",
+		},
+	} {
+		buf := &strings.Builder{}
+		if err := test.plan.WriteTable(buf); err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, buf.String(), test.expected)
+	}
+}
@@ -0,0 +1,22 @@
+package scaffold
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"strings"
+)
+
+// stdin is shared by all prompts, so that input buffered while reading one
+// answer is available to the next prompt.
+var stdin = bufio.NewReader(os.Stdin)
+
+// readLine reads a single line of input from stdin, without the line ending.
+// It returns io.EOF only if no more input is available.
+func readLine() (string, error) {
+	line, err := stdin.ReadString('\n')
+	if err != nil && !(err == io.EOF && line != "") {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
@@ -6,103 +6,117 @@ import (
 	"path"
 
 	"github.com/olafal0/rescaffold/config"
+	"github.com/olafal0/rescaffold/set"
 )
 
-func Remove(lockfile *config.Lockfile, scaffoldSource, outdir string) error {
-	scaf, err := LoadScaffold(scaffoldSource)
+// PlanRemove plans the removal of a scaffold's files from outdir. Files that
+// have been modified since they were generated are left in place.
+func PlanRemove(lockfile *config.Lockfile, scaffoldSource, outdir string, opts Options) (*Plan, error) {
+	// Load the scaffold at the commit its files were generated from, so that
+	// output paths match those in the lockfile
+	ref := ""
+	if lockedScaffold, ok := lockfile.Scaffolds[scaffoldSource]; ok {
+		ref = lockedScaffold.Commit
+	}
+	scaf, err := LoadScaffold(scaffoldSource, ref, opts.Offline)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	defer scaf.Cleanup()
 
-	lockedScaffold := lockfile.GetScaffold(scaffoldSource, scaf.Manifest)
+	lockedScaffold := plannedScaffold(lockfile, scaffoldSource)
+	plan := &Plan{
+		Source: scaffoldSource,
+		outdir: outdir,
+	}
+
+	// Create a set of output filenames that are present in the lockfile, so that
+	// tracked files the scaffold no longer generates are removed too
+	lockedFilePaths := set.NewWithCap[string](len(lockedScaffold.Files))
+	for _, lockedFile := range lockedScaffold.Files {
+		lockedFilePaths.Add(lockedFile.Path)
+	}
 
 	// Find all vars in the manifest
 	// If any do not have values in the lockfile, prompt the user for them
-	varValues, err := LoadVarsInteractive(scaf.Manifest.Vars, lockedScaffold.Vars)
+	varValues, err := resolveVars(scaf.Manifest, lockedScaffold.Vars, opts)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	lockedScaffold.Vars = varValues
 
-	replacer, err := RegexpLoopReplacer(scaf.Manifest, varValues)
+	tmpl, err := NewTemplate(scaf.Manifest, varValues)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	for _, scaffoldFile := range scaf.Files {
-		outFilename := replacer(scaffoldFile.RelativePath)
+	scaffoldFiles, err := scaf.IncludedFiles(varValues)
+	if err != nil {
+		return nil, err
+	}
+	targets, err := tmpl.targets(scaffoldFiles)
+	if err != nil {
+		return nil, err
+	}
+	for _, target := range targets {
+		outFilename := target.tmpl.Replace(target.RelativePath)
 		outpath := path.Join(outdir, outFilename)
 
 		// Get file info from lockfile (may be nil)
 		lockedFile := lockedScaffold.GetFile(outpath)
+		lockedFilePaths.Remove(outpath)
 
 		// Check if file exists at destination
-		existingOutfile, err := os.Open(outpath)
-		if err != nil && !os.IsNotExist(err) {
-			return fmt.Errorf("error checking if file exists: %w", err)
+		checksum, exists, err := existingChecksum(outpath)
+		if err != nil {
+			return nil, err
 		}
 
-		if existingOutfile == nil {
+		if !exists {
 			// File does not exist. If it's not in the lockfile, nothing to do
 			if lockedFile == nil {
 				continue
 			}
 
 			// Otherwise, remove the file from the lockfile
-			lockedScaffold.RemoveFile(outpath)
-			if err := lockfile.WriteUpdated(); err != nil {
-				return fmt.Errorf("error writing updated lockfile: %w", err)
-			}
+			plan.add(ActionUntrack, outpath, "")
 			continue
 		}
 
 		// If file exists, check that its contents are what we expect (matching checksum)
 		if lockedFile == nil {
-			return fmt.Errorf("file exists but is not in lockfile: %s", outpath)
-		}
-
-		checksum, err := hashFile(existingOutfile)
-		if err != nil {
-			return err
+			plan.add(ActionConflict, outpath, "file exists but is not in lockfile")
+			continue
 		}
 
 		if lockedFile.Checksum != checksum {
-			fmt.Printf("file has been modified, skipping: %s\n", outpath)
+			plan.add(ActionSkipModified, outpath, "file has been modified, skipping")
 			continue
 		}
 
 		// Output file exists and matches expected value. We're removing, so this
 		// file should be deleted
-
-		if err := os.Remove(outpath); err != nil {
-			return fmt.Errorf("error removing file: %w", err)
-		}
-
-		// Update lockfile with new file information for each new file
-		lockedScaffold.RemoveFile(outpath)
-		if err := lockfile.WriteUpdated(); err != nil {
-			return fmt.Errorf("error writing updated lockfile: %w", err)
-		}
+		plan.add(ActionDelete, outpath, "")
 	}
 
-	lockfile.RemoveScaffold(scaffoldSource)
-	if len(lockfile.Scaffolds) > 0 {
-		if err := lockfile.WriteUpdated(); err != nil {
-			return fmt.Errorf("error writing updated lockfile: %w", err)
+	for _, lockedFile := range lockedScaffold.Files {
+		if !lockedFilePaths.Contains(lockedFile.Path) {
+			continue
+		}
+		checksum, exists, err := existingChecksum(lockedFile.Path)
+		if err != nil {
+			return nil, fmt.Errorf("file present in lockfile, but could not open: %w", err)
 		}
-	} else {
-		if err := lockfile.Remove(); err != nil {
-			return fmt.Errorf("error removing lockfile: %w", err)
+		switch {
+		case !exists:
+			plan.add(ActionUntrack, lockedFile.Path, "")
+		case lockedFile.Checksum != checksum:
+			plan.add(ActionSkipModified, lockedFile.Path, "file has been modified, skipping")
+		default:
+			plan.add(ActionDelete, lockedFile.Path, "")
 		}
 	}
 
-	// Walk outdir and remove any empty directories
-	_, err = removeEmptyDirectories("", outdir)
-	if err != nil {
-		return err
-	}
-	return nil
+	return plan, nil
 }
 
 func removeEmptyDirectories(basePath, dir string) (removed bool, err error) {
@@ -6,12 +6,10 @@ import (
 	"io/fs"
 	"log"
 	"os"
-	"os/exec"
 	"path"
+	"path/filepath"
 	"strings"
 
-	giturls "github.com/chainguard-dev/git-urls"
-	"github.com/google/uuid"
 	"github.com/olafal0/rescaffold/config"
 	"github.com/olafal0/rescaffold/set"
 )
@@ -25,14 +23,28 @@ type ScaffoldFile struct {
 	RelativePath string
 	// FullPath is the absolute path to the file
 	FullPath string
+	// Mode holds the file's permission bits, and fs.ModeSymlink if the file is
+	// a symbolic link
+	Mode fs.FileMode
+	// LinkTarget is the target of a symbolic link, which may use template
+	// replacement
+	LinkTarget string
 }
 
 type Scaffold struct {
 	Files    []ScaffoldFile
 	Manifest *config.Manifest
 	Vars     map[string]string
-	src      string
-	cleanup  func() error
+	// Commit is the git commit the scaffold was loaded from, if it was cloned
+	// from a git source
+	Commit string
+	src    string
+	// dir is the local directory containing the scaffold root
+	dir string
+	// shallow is set if dir is a checkout of a shallow git repository, whose
+	// missing history can be fetched
+	shallow bool
+	cleanup func() error
 }
 
 func (s *Scaffold) Cleanup() {
@@ -44,20 +56,24 @@ func (s *Scaffold) Cleanup() {
 	}
 }
 
-func LoadScaffold(source string) (*Scaffold, error) {
-	_, err := giturls.ParseScp(source)
-	if err == nil {
-		return LoadFromURL(source)
+// LoadScaffold loads a scaffold from a git URL or local directory, optionally
+// followed by a subdirectory containing the scaffold, as in "source//subdir".
+// If ref is not empty, the scaffold is loaded from that git ref; local
+// directories must be git repositories in order to use a ref. If offline is
+// set, git sources are loaded from the cache without fetching.
+func LoadScaffold(source, ref string, offline bool) (*Scaffold, error) {
+	repo, subdir := splitSubdir(source)
+	if err := checkSubdir(subdir); err != nil {
+		return nil, err
 	}
-	_, err = giturls.ParseTransport(source)
-	if err == nil {
-		return LoadFromURL(source)
+	if isGitURL(repo) || ref != "" {
+		return loadFromGit(repo, ref, subdir, offline)
 	}
-	return LoadFromDir(source)
+	return LoadFromDir(path.Join(repo, subdir))
 }
 
 func LoadFromDir(dirName string) (*Scaffold, error) {
-	subScaffolds, err := findScaffolds(dirName, "", 0)
+	subScaffolds, err := findScaffolds(dirName, "", 0, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -65,15 +81,16 @@ func LoadFromDir(dirName string) (*Scaffold, error) {
 	case 0:
 		return nil, fmt.Errorf("found no %s", config.ManifestFilename)
 	case 1:
-		if subScaffolds[0] != dirName {
-			fmt.Printf("base directory is not a scaffold, using %s as the root\n", subScaffolds[0])
-			dirName = subScaffolds[0]
+		if subScaffolds[0] != "" {
+			dirName = path.Join(dirName, subScaffolds[0])
+			fmt.Printf("base directory is not a scaffold, using %s as the root\n", dirName)
 		}
 	default:
-		return nil, fmt.Errorf("ambiguous scaffold reference, found sub-scaffolds: %v", subScaffolds)
+		return nil, fmt.Errorf("ambiguous scaffold reference, found sub-scaffolds: %s (select one with source%ssubdir)",
+			strings.Join(subScaffolds, ", "), SubdirSeparator)
 	}
 
-	filenames, err := walkDir(dirName, "")
+	filenames, err := walkDir(dirName, "", nil)
 	if err != nil {
 		return nil, err
 	}
@@ -85,6 +102,7 @@ func LoadFromDir(dirName string) (*Scaffold, error) {
 
 	scaffold := &Scaffold{
 		src: dirName,
+		dir: dirName,
 	}
 	scaffold.Files = make([]ScaffoldFile, 0, len(filenames))
 	for _, filename := range filenames {
@@ -105,14 +123,30 @@ func LoadFromDir(dirName string) (*Scaffold, error) {
 			continue
 		}
 
+		info, err := os.Lstat(filename)
+		if err != nil {
+			return nil, err
+		}
 		scafFile := ScaffoldFile{
 			RelativePath: path.Clean(strings.TrimPrefix(filename, dirName)),
+			Mode:         info.Mode() & (fs.ModeSymlink | fs.ModePerm),
 		}
 		if path.IsAbs(filename) {
 			scafFile.FullPath = filename
 		} else {
 			scafFile.FullPath = path.Join(wd, filename)
 		}
+		if info.Mode()&fs.ModeSymlink != 0 {
+			scafFile.LinkTarget, err = os.Readlink(filename)
+			if err != nil {
+				return nil, err
+			}
+			if err := checkLinkTarget(scafFile.RelativePath, scafFile.LinkTarget); err != nil {
+				return nil, err
+			}
+		} else if !info.Mode().IsRegular() {
+			return nil, fmt.Errorf("%s is not a regular file, directory, or symlink", filename)
+		}
 		scaffold.Files = append(scaffold.Files, scafFile)
 	}
 	if scaffold.Manifest == nil {
@@ -121,87 +155,158 @@ func LoadFromDir(dirName string) (*Scaffold, error) {
 	return scaffold, nil
 }
 
-func LoadFromURL(source string) (*Scaffold, error) {
-	destDir := "/tmp/rescaffold-" + uuid.NewString()
-	cmd := exec.Command("bash", "-c",
-		fmt.Sprintf("git clone %s %s", source, destDir),
-	)
-	cmd.Stderr = os.Stderr
-	cmd.Stdout = os.Stdout
-	cmd.Stdin = os.Stdin
-	if err := cmd.Run(); err != nil {
-		return nil, err
-	}
+// LoadFromURL fetches a git repository into the cache and loads the scaffold
+// within it. If ref is not empty, it is checked out instead of the default
+// branch. If offline is set, the scaffold is loaded from a cached commit.
+func LoadFromURL(source, ref string, offline bool) (*Scaffold, error) {
+	return loadFromGit(source, ref, "", offline)
+}
 
-	cleanupFunc := func() error {
-		if err := os.RemoveAll(destDir); err != nil {
-			return err
-		}
-		return nil
+// loadFromGit fetches a git repository and loads the scaffold in subdir, a path
+// relative to the root of the repository.
+func loadFromGit(source, ref, subdir string, offline bool) (*Scaffold, error) {
+	destDir, commit, cleanupFunc, err := fetchGit(source, ref, offline)
+	if err != nil {
+		return nil, err
 	}
-
-	scaf, err := LoadFromDir(destDir)
+	scaf, err := LoadFromDir(path.Join(destDir, subdir))
 	if err != nil {
 		log.Printf("failed to load cloned dir: %v\n", err)
-		return nil, cleanupFunc()
+		cleanupFunc()
+		return nil, err
 	}
 	scaf.src = source
+	scaf.Commit = commit
+	scaf.shallow = !offline
 	scaf.cleanup = cleanupFunc
 	return scaf, nil
 }
 
-func isIgnored(f fs.DirEntry) bool {
-	return IgnoreFiles.Contains(path.Base(f.Name()))
+// fetchGit fetches ref from a git repository, and returns a directory containing
+// a checkout of it, the resolved commit, and a function that cleans up the
+// checkout after use.
+func fetchGit(source, ref string, offline bool) (dir, commit string, cleanup func() error, err error) {
+	remote := source
+	if !isGitURL(source) {
+		// Local repositories are fetched from directly, whether or not offline
+		// is set, and relative paths must not depend on where git is run
+		absPath, err := filepath.Abs(source)
+		if err != nil {
+			return "", "", nil, err
+		}
+		remote = absPath
+		offline = false
+	}
+	root, cleanup, err := gitCacheRoot(source)
+	if err != nil {
+		return "", "", nil, err
+	}
+	dir, commit, err = checkoutGit(root, remote, ref, offline)
+	if err != nil {
+		cleanup()
+		return "", "", nil, err
+	}
+	return dir, commit, cleanup, nil
+}
+
+// checkoutGit resolves ref in the git repository at remote, and returns a
+// directory within root containing a checkout of the resolved commit.
+func checkoutGit(root, remote, ref string, offline bool) (dir, commit string, err error) {
+	cache, err := openSourceCache(root, remote, offline)
+	if err != nil {
+		return "", "", err
+	}
+	commit, err = cache.resolve(ref)
+	if err != nil {
+		return "", "", err
+	}
+	dir, err = cache.checkout(commit)
+	if err != nil {
+		return "", "", err
+	}
+	return dir, commit, nil
+}
+
+// checkLinkTarget returns an error if a symlink at relativePath (relative to
+// the scaffold or output root) has an absolute target, or a target outside the
+// root. Such links could not be recreated in another project.
+func checkLinkTarget(relativePath, target string) error {
+	relativePath = strings.TrimPrefix(relativePath, "/")
+	resolved := path.Join(path.Dir(relativePath), target)
+	if path.IsAbs(target) || resolved == ".." || strings.HasPrefix(resolved, "../") {
+		return fmt.Errorf("symlink %s points outside the scaffold: %s", relativePath, target)
+	}
+	return nil
 }
 
-func walkDir(basePath, dir string) ([]string, error) {
-	files, err := os.ReadDir(path.Join(basePath, dir))
+// walkDir returns the paths of all files within dir, which is relative to
+// root, leaving out ignored files. The paths returned include root.
+func walkDir(root, dir string, rules ignoreRules) ([]string, error) {
+	rules, err := rules.withDir(root, dir)
+	if err != nil {
+		return nil, err
+	}
+	files, err := os.ReadDir(path.Join(root, dir))
 	if err != nil {
 		return nil, err
 	}
 
 	filePaths := make([]string, 0, len(files))
 	for _, file := range files {
-		if isIgnored(file) {
+		relPath := path.Join(dir, file.Name())
+		if file.Name() == IgnoreFilename {
+			continue
+		}
+		// The manifest can't be excluded, since the scaffold needs it
+		if file.Name() != config.ManifestFilename && rules.isIgnored(relPath, file.IsDir()) {
 			continue
 		}
 		if file.IsDir() {
-			subFiles, err := walkDir(path.Join(basePath, dir), file.Name())
+			subFiles, err := walkDir(root, relPath, rules)
 			if err != nil {
 				return nil, err
 			}
 			filePaths = append(filePaths, subFiles...)
 		} else {
-			filePaths = append(filePaths, path.Join(basePath, dir, file.Name()))
+			filePaths = append(filePaths, path.Join(root, relPath))
 		}
 	}
 	return filePaths, nil
 }
 
-const maxScaffoldSearchLevel = 1
+// maxScaffoldSearchLevel is how many directories deep scaffolds are searched
+// for, so that repositories can organize many scaffolds into groups.
+const maxScaffoldSearchLevel = 4
 
-func findScaffolds(basePath, dir string, level int) ([]string, error) {
+// findScaffolds returns the directories within dir, which is relative to root,
+// that contain a manifest. The directories returned are relative to root, and
+// are empty for root itself. Ignored directories are not searched.
+func findScaffolds(root, dir string, level int, rules ignoreRules) ([]string, error) {
 	if level > maxScaffoldSearchLevel {
 		return nil, nil
 	}
-	searchDir := path.Join(basePath, dir)
-	files, err := os.ReadDir(searchDir)
+	rules, err := rules.withDir(root, dir)
+	if err != nil {
+		return nil, err
+	}
+	files, err := os.ReadDir(path.Join(root, dir))
 	if err != nil {
 		return nil, err
 	}
 
 	scaffoldDirs := []string{}
 	for _, file := range files {
-		if isIgnored(file) {
+		relPath := path.Join(dir, file.Name())
+		if rules.isIgnored(relPath, file.IsDir()) {
 			continue
 		}
 		if file.Type().IsRegular() {
 			if path.Base(file.Name()) == config.ManifestFilename {
-				scaffoldDirs = append(scaffoldDirs, searchDir)
+				scaffoldDirs = append(scaffoldDirs, dir)
 			}
 		}
 		if file.IsDir() {
-			others, err := findScaffolds(searchDir, file.Name(), level+1)
+			others, err := findScaffolds(root, relPath, level+1, rules)
 			if err != nil {
 				return nil, err
 			}
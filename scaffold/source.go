@@ -0,0 +1,119 @@
+package scaffold
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	giturls "github.com/chainguard-dev/git-urls"
+)
+
+// SubdirSeparator separates a scaffold source from the subdirectory containing
+// the scaffold, e.g. "https://github.com/me/scaffolds//go/service".
+const SubdirSeparator = "//"
+
+// subdirFragment introduces the subdirectory in the alternative fragment syntax,
+// e.g. "https://github.com/me/scaffolds#path=go/service".
+const subdirFragment = "#path="
+
+// ParseSource splits a scaffold reference into the scaffold source and the
+// requested git ref (a tag, branch, or commit). References have the form
+// "source//subdir@ref" or "source@ref#path=subdir", where the subdirectory and
+// ref are optional. If no ref is present, ref is empty. The returned source
+// includes the subdirectory, if any, in the form "source//subdir", and local
+// directory sources are cleaned. A local directory whose name contains "@" is
+// not split, as long as it exists.
+func ParseSource(s string) (source, ref string) {
+	fragmentSubdir := ""
+	if i := strings.Index(s, subdirFragment); i >= 0 {
+		s, fragmentSubdir = s[:i], s[i+len(subdirFragment):]
+	}
+
+	source = s
+	if i := strings.LastIndex(s, "@"); i >= 0 {
+		candidate := s[i+1:]
+		switch {
+		case candidate == "":
+		case strings.Contains(candidate, ":"):
+			// scp-like syntax, e.g. git@github.com:user/repo
+		case strings.Contains(s[:i], "://") && !strings.Contains(s[strings.Index(s, "://")+3:i], "/"):
+			// userinfo in a URL, e.g. ssh://git@github.com/user/repo
+		case !isGitURL(s[:i]) && isLocalDir(s):
+			// a local directory with "@" in its name, e.g. ./templates/foo@2
+		default:
+			source, ref = s[:i], candidate
+		}
+	}
+
+	repo, subdir := splitSubdir(source)
+	if !isGitURL(repo) {
+		repo = path.Clean(repo)
+	}
+	return JoinSource(repo, path.Join(subdir, fragmentSubdir)), ref
+}
+
+// splitSubdir splits a source of the form "repo//subdir" into the repository (or
+// local directory) and the subdirectory within it. If there is no
+// subdirectory, subdir is empty.
+func splitSubdir(source string) (repo, subdir string) {
+	start := 0
+	if i := strings.Index(source, "://"); i >= 0 {
+		// Skip the URL scheme separator
+		start = i + len("://")
+	}
+	i := strings.Index(source[start:], SubdirSeparator)
+	if i < 0 {
+		return source, ""
+	}
+	return source[:start+i], path.Clean(source[start+i+len(SubdirSeparator):])
+}
+
+// JoinSource returns the source of the scaffold in subdir, a directory relative
+// to the root of repo.
+func JoinSource(repo, subdir string) string {
+	subdir = path.Clean(subdir)
+	if subdir == "." {
+		return repo
+	}
+	return repo + SubdirSeparator + subdir
+}
+
+// checkSubdir returns an error if subdir is not a relative path within the
+// source.
+func checkSubdir(subdir string) error {
+	if path.IsAbs(subdir) || subdir == ".." || strings.HasPrefix(subdir, "../") {
+		return fmt.Errorf("scaffold subdirectory %q must be a relative path within the source", subdir)
+	}
+	return nil
+}
+
+// isLocalDir reports whether source, which may include a subdirectory, is an
+// existing local directory.
+func isLocalDir(source string) bool {
+	repo, subdir := splitSubdir(source)
+	info, err := os.Stat(filepath.Join(filepath.FromSlash(repo), filepath.FromSlash(subdir)))
+	return err == nil && info.IsDir()
+}
+
+func isGitURL(source string) bool {
+	if _, err := giturls.ParseScp(source); err == nil {
+		return true
+	}
+	if _, err := giturls.ParseTransport(source); err == nil {
+		return true
+	}
+	return false
+}
+
+// shortCommit abbreviates a commit hash for display.
+func shortCommit(commit string) string {
+	if commit == "" {
+		return "unknown"
+	}
+	if len(commit) > 7 {
+		return commit[:7]
+	}
+	return commit
+}
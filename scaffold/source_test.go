@@ -0,0 +1,104 @@
+package scaffold_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/olafal0/rescaffold/assert"
+	"github.com/olafal0/rescaffold/config"
+	"github.com/olafal0/rescaffold/scaffold"
+)
+
+func TestParseSource(t *testing.T) {
+	for s, expected := range map[string][2]string{
+		"https://github.com/me/scaffold":                 {"https://github.com/me/scaffold", ""},
+		"https://github.com/me/scaffold@v1.2.0":          {"https://github.com/me/scaffold", "v1.2.0"},
+		"https://github.com/me/scaffolds//go/service":    {"https://github.com/me/scaffolds//go/service", ""},
+		"https://github.com/me/scaffolds//go/service@v1": {"https://github.com/me/scaffolds//go/service", "v1"},
+		"https://github.com/me/scaffolds#path=go":        {"https://github.com/me/scaffolds//go", ""},
+		"https://github.com/me/scaffolds@v1#path=go/":    {"https://github.com/me/scaffolds//go", "v1"},
+		"ssh://git@github.com/me/scaffolds//go":          {"ssh://git@github.com/me/scaffolds//go", ""},
+		"git@github.com:me/scaffolds//go@main":           {"git@github.com:me/scaffolds//go", "main"},
+		"file:///srv/scaffolds//go":                      {"file:///srv/scaffolds//go", ""},
+		"./scaffolds/":                                   {"scaffolds", ""},
+		"./scaffolds//go/./service/":                     {"scaffolds//go/service", ""},
+		"../scaffolds@feature/x#path=web":                {"../scaffolds//web", "feature/x"},
+		"/srv/scaffolds//.":                              {"/srv/scaffolds", ""},
+	} {
+		source, ref := scaffold.ParseSource(s)
+		assert.Equal(t, source, expected[0])
+		assert.Equal(t, ref, expected[1])
+	}
+}
+
+func TestParseSourceLocalAt(t *testing.T) {
+	dir := filepath.ToSlash(t.TempDir())
+	if err := os.MkdirAll(filepath.Join(dir, "foo@2", "service"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	for s, expected := range map[string][2]string{
+		dir + "/foo@2":             {dir + "/foo@2", ""},
+		dir + "/foo@2/":            {dir + "/foo@2", ""},
+		dir + "/foo@2//service":    {dir + "/foo@2//service", ""},
+		dir + "/foo@2//service@v1": {dir + "/foo@2//service", "v1"},
+		dir + "/foo@2@v1":          {dir + "/foo@2", "v1"},
+		dir + "/bar@2":             {dir + "/bar", "2"},
+	} {
+		source, ref := scaffold.ParseSource(s)
+		assert.Equal(t, source, expected[0])
+		assert.Equal(t, ref, expected[1])
+	}
+}
+
+func TestDiscover(t *testing.T) {
+	root := t.TempDir()
+	for dir, title := range map[string]string{
+		"go/service": "Go service",
+		"go/cli":     "Go CLI",
+		"a/b/c/d":    "Deep",
+		"web":        "Web",
+	} {
+		if err := os.MkdirAll(filepath.Join(root, dir), 0755); err != nil {
+			t.Fatal(err)
+		}
+		manifest := "[meta]\ntitle = \"" + title + "\"\n"
+		if err := os.WriteFile(filepath.Join(root, dir, config.ManifestFilename), []byte(manifest), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	discovered, err := scaffold.Discover(root, "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	titles := map[string]string{}
+	for _, found := range discovered {
+		titles[found.Source] = found.Manifest.Meta.Title
+	}
+	assert.Equal(t, len(titles), 4)
+	assert.Equal(t, titles[root+"//go/service"], "Go service")
+	assert.Equal(t, titles[root+"//a/b/c/d"], "Deep")
+
+	discovered, err = scaffold.Discover(root+"//go", "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, len(discovered), 2)
+	assert.Equal(t, discovered[0].Source, root+"//go/cli")
+
+	// Each discovered scaffold can be loaded by its source
+	scaf, err := scaffold.LoadScaffold(discovered[1].Source, "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, scaf.Manifest.Meta.Title, "Go service")
+
+	if _, err := scaffold.LoadScaffold(root, "", false); err == nil {
+		t.Error("expected error loading a source with several scaffolds")
+	}
+	if _, err := scaffold.LoadScaffold(root+"//../x", "", false); err == nil {
+		t.Error("expected error loading a subdirectory outside the source")
+	}
+}
@@ -0,0 +1,167 @@
+package scaffold
+
+import (
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"text/tabwriter"
+
+	"github.com/olafal0/rescaffold/config"
+)
+
+// FileState describes how a file compares to the lockfile.
+type FileState string
+
+const (
+	// FileClean is a tracked file that matches the lockfile
+	FileClean FileState = "clean"
+	// FileModified is a tracked file that has been changed since it was
+	// generated
+	FileModified FileState = "modified"
+	// FileMissing is a tracked file that has been deleted since it was
+	// generated
+	FileMissing FileState = "missing"
+	// FileUntracked is a file that the scaffold generates, which exists but is
+	// not tracked in the lockfile
+	FileUntracked FileState = "untracked"
+)
+
+// FileStatus is the state of a single file.
+type FileStatus struct {
+	Path  string    `json:"path"`
+	State FileState `json:"state"`
+}
+
+// ScaffoldStatus is the state of the files of a scaffold.
+type ScaffoldStatus struct {
+	Source string       `json:"source"`
+	Commit string       `json:"commit,omitempty"`
+	Files  []FileStatus `json:"files"`
+}
+
+// Clean reports whether all of the scaffold's files are clean.
+func (s ScaffoldStatus) Clean() bool {
+	for _, file := range s.Files {
+		if file.State != FileClean {
+			return false
+		}
+	}
+	return true
+}
+
+// StatusOptions control which files Status reports on.
+type StatusOptions struct {
+	// Untracked loads each scaffold at its locked commit, to find the files it
+	// generates that exist but aren't tracked
+	Untracked bool
+	// Offline loads git sources from the cache instead of fetching them
+	Offline bool
+}
+
+// Status compares the files tracked for each scaffold in the lockfile with the
+// files in outdir. Scaffolds are sorted by source, and files by path.
+func Status(lockfile *config.Lockfile, outdir string, opts StatusOptions) ([]ScaffoldStatus, error) {
+	statuses := make([]ScaffoldStatus, 0, len(lockfile.Scaffolds))
+	for source, lockedScaffold := range lockfile.Scaffolds {
+		status := ScaffoldStatus{
+			Source: source,
+			Commit: lockedScaffold.Commit,
+			Files:  make([]FileStatus, 0, len(lockedScaffold.Files)),
+		}
+		for _, lockedFile := range lockedScaffold.Files {
+			checksum, exists, err := existingChecksum(lockedFile.Path)
+			if err != nil {
+				return nil, err
+			}
+			state := FileClean
+			switch {
+			case !exists:
+				state = FileMissing
+			case checksum != lockedFile.Checksum:
+				state = FileModified
+			}
+			status.Files = append(status.Files, FileStatus{Path: lockedFile.Path, State: state})
+		}
+
+		if opts.Untracked {
+			untracked, err := untrackedFiles(source, lockedScaffold, outdir, opts.Offline)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", source, err)
+			}
+			for _, outpath := range untracked {
+				status.Files = append(status.Files, FileStatus{Path: outpath, State: FileUntracked})
+			}
+		}
+
+		sort.Slice(status.Files, func(i, j int) bool {
+			return status.Files[i].Path < status.Files[j].Path
+		})
+		statuses = append(statuses, status)
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].Source < statuses[j].Source
+	})
+	return statuses, nil
+}
+
+// untrackedFiles returns the paths of the files that a scaffold generates with
+// its locked vars, which exist in outdir but are not tracked in the lockfile.
+func untrackedFiles(source string, lockedScaffold *config.LockfileScaffold, outdir string, offline bool) ([]string, error) {
+	// Load the scaffold at the commit its files were generated from, so that
+	// output paths match those in the lockfile
+	scaf, err := LoadScaffold(source, lockedScaffold.Commit, offline)
+	if err != nil {
+		return nil, err
+	}
+	defer scaf.Cleanup()
+
+	varValues, err := resolveVars(scaf.Manifest, lockedScaffold.Vars, Options{NonInteractive: true})
+	if err != nil {
+		return nil, err
+	}
+	tmpl, err := NewTemplate(scaf.Manifest, varValues)
+	if err != nil {
+		return nil, err
+	}
+	scaffoldFiles, err := scaf.IncludedFiles(varValues)
+	if err != nil {
+		return nil, err
+	}
+	targets, err := tmpl.targets(scaffoldFiles)
+	if err != nil {
+		return nil, err
+	}
+
+	untracked := []string{}
+	for _, target := range targets {
+		outpath := path.Join(outdir, target.tmpl.Replace(target.RelativePath))
+		if lockedScaffold.GetFile(outpath) != nil {
+			continue
+		}
+		_, exists, err := existingChecksum(outpath)
+		if err != nil {
+			return nil, err
+		}
+		if exists {
+			untracked = append(untracked, outpath)
+		}
+	}
+	return untracked, nil
+}
+
+// WriteTable writes a human-readable table of the scaffold's files to w.
+func (s ScaffoldStatus) WriteTable(w io.Writer) error {
+	header := s.Source
+	if s.Commit != "" {
+		header += " @ " + shortCommit(s.Commit)
+	}
+	if _, err := fmt.Fprintln(w, header); err != nil {
+		return err
+	}
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	for _, file := range s.Files {
+		fmt.Fprintf(tw, "  %s\t%s\n", file.State, file.Path)
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,116 @@
+package scaffold_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/olafal0/rescaffold/assert"
+	"github.com/olafal0/rescaffold/config"
+	"github.com/olafal0/rescaffold/scaffold"
+)
+
+const testManifest = "[meta]\ntitle = \"Test\"\n\n[config]\nopen_delim = \"_\"\nclose_delim = \"_\"\n\n[vars.name]\ndefault = \"app\"\n"
+
+// testWriteFiles writes files with the given contents to dir, creating their
+// parent directories.
+func testWriteFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		if err := os.MkdirAll(filepath.Dir(filepath.Join(dir, name)), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// testGenerate generates the scaffold in scaffoldDir into a new directory, and
+// returns the directory and its lockfile.
+func testGenerate(t *testing.T, scaffoldDir string) (string, *config.Lockfile) {
+	t.Helper()
+	outdir := t.TempDir()
+	lockfile, err := config.LoadLockfile(filepath.Join(outdir, config.LockfileFilename))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { lockfile.Close() })
+	plan, err := scaffold.PlanGenerate(lockfile, scaffoldDir, "", outdir, scaffold.Options{NonInteractive: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := scaffold.Apply(lockfile, plan); err != nil {
+		t.Fatal(err)
+	}
+	return outdir, lockfile
+}
+
+func TestStatus(t *testing.T) {
+	scaffoldDir := t.TempDir()
+	testWriteFiles(t, scaffoldDir, map[string]string{
+		config.ManifestFilename: testManifest,
+		"clean.txt":             "clean\n",
+		"modified.txt":          "modified\n",
+		"missing.txt":           "missing\n",
+		"untracked.txt":         "untracked\n",
+	})
+	outdir, lockfile := testGenerate(t, scaffoldDir)
+
+	if err := os.WriteFile(filepath.Join(outdir, "modified.txt"), []byte("changed\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(filepath.Join(outdir, "missing.txt")); err != nil {
+		t.Fatal(err)
+	}
+	lockfile.Scaffolds[scaffoldDir].RemoveFile(filepath.Join(outdir, "untracked.txt"))
+
+	statuses, err := scaffold.Status(lockfile, outdir, scaffold.StatusOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, len(statuses), 1)
+	assert.Equal(t, statuses[0].Clean(), false)
+	states := map[string]scaffold.FileState{}
+	for _, file := range statuses[0].Files {
+		states[filepath.Base(file.Path)] = file.State
+	}
+	assert.Equal(t, len(states), 3)
+	assert.Equal(t, states["clean.txt"], scaffold.FileClean)
+	assert.Equal(t, states["modified.txt"], scaffold.FileModified)
+	assert.Equal(t, states["missing.txt"], scaffold.FileMissing)
+
+	statuses, err = scaffold.Status(lockfile, outdir, scaffold.StatusOptions{Untracked: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, len(statuses[0].Files), 4)
+	assert.Equal(t, statuses[0].Files[3].Path, filepath.Join(outdir, "untracked.txt"))
+	assert.Equal(t, statuses[0].Files[3].State, scaffold.FileUntracked)
+}
+
+func TestStatusClean(t *testing.T) {
+	scaffoldDir := t.TempDir()
+	testWriteFiles(t, scaffoldDir, map[string]string{
+		config.ManifestFilename: testManifest,
+		"_name_.txt":            "_name_\n",
+	})
+	outdir, lockfile := testGenerate(t, scaffoldDir)
+
+	statuses, err := scaffold.Status(lockfile, outdir, scaffold.StatusOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, len(statuses), 1)
+	assert.Equal(t, statuses[0].Source, scaffoldDir)
+	assert.Equal(t, statuses[0].Clean(), true)
+	assert.Equal(t, len(statuses[0].Files), 1)
+	assert.Equal(t, statuses[0].Files[0].Path, filepath.Join(outdir, "app.txt"))
+
+	buf := &strings.Builder{}
+	if err := statuses[0].WriteTable(buf); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, buf.String(), scaffoldDir+"\n  clean  "+filepath.Join(outdir, "app.txt")+"\n")
+}
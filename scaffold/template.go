@@ -2,10 +2,15 @@ package scaffold
 
 import (
 	"fmt"
+	"io"
 	"regexp"
+	"sort"
 	"strings"
+	"unicode"
 
 	"github.com/olafal0/rescaffold/config"
+	"github.com/olafal0/rescaffold/expr"
+	"github.com/olafal0/rescaffold/glob"
 	"github.com/olafal0/rescaffold/set"
 	"golang.org/x/text/cases"
 	"golang.org/x/text/language"
@@ -15,6 +20,39 @@ var Modifiers = map[string]func(string) string{
 	"titlecase": cases.Title(language.English).String,
 	"lowercase": strings.ToLower,
 	"uppercase": strings.ToUpper,
+	"snakecase": func(s string) string { return strings.Join(words(s), "_") },
+	"kebabcase": func(s string) string { return strings.Join(words(s), "-") },
+}
+
+// words splits a string into lowercase words, breaking on any character that
+// isn't a letter or digit, and between camel case humps (e.g. "HTTPServer"
+// becomes "http", "server").
+func words(s string) []string {
+	runes := []rune(s)
+	result := []string{}
+	current := []rune{}
+	for i, r := range runes {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			if len(current) > 0 {
+				result = append(result, string(current))
+				current = current[:0]
+			}
+			continue
+		}
+		if unicode.IsUpper(r) && len(current) > 0 {
+			prev := runes[i-1]
+			nextIsLower := i+1 < len(runes) && unicode.IsLower(runes[i+1])
+			if unicode.IsLower(prev) || unicode.IsDigit(prev) || (unicode.IsUpper(prev) && nextIsLower) {
+				result = append(result, string(current))
+				current = current[:0]
+			}
+		}
+		current = append(current, unicode.ToLower(r))
+	}
+	if len(current) > 0 {
+		result = append(result, string(current))
+	}
+	return result
 }
 
 // LiteralMatchReplacer returns a function that will perform template replacement on a string
@@ -44,19 +82,7 @@ func LiteralMatchReplacer(manifest *config.Manifest, vars map[string]string) fun
 }
 
 func RegexpReplacer(manifest *config.Manifest, vars map[string]string) (func(string) string, error) {
-	openDelim := regexp.QuoteMeta(manifest.Config.OpenDelim)
-	closeDelim := regexp.QuoteMeta(manifest.Config.CloseDelim)
-	modifierDelim := regexp.QuoteMeta(manifest.Config.ModifierDelim)
-	// Create regex from var and modifier names
-	// Example: "x_(name|port)((?:\|(?:titlecase|lowercase))*)_"
-	regexpStr := fmt.Sprintf(`%[1]s(%[2]s)((?:%[3]s(?:%[4]s))*)%[5]s`,
-		openDelim,
-		strings.Join(set.Keys(vars), "|"),
-		modifierDelim,
-		strings.Join(set.Keys(Modifiers), "|"),
-		closeDelim,
-	)
-	matcher, err := regexp.Compile(regexpStr)
+	matcher, err := varRefMatcher(manifest, set.Keys(vars))
 	if err != nil {
 		return nil, err
 	}
@@ -111,19 +137,7 @@ func RegexpReplacer(manifest *config.Manifest, vars map[string]string) (func(str
 }
 
 func RegexpLoopReplacer(manifest *config.Manifest, vars map[string]string) (func(string) string, error) {
-	openDelim := regexp.QuoteMeta(manifest.Config.OpenDelim)
-	closeDelim := regexp.QuoteMeta(manifest.Config.CloseDelim)
-	modifierDelim := regexp.QuoteMeta(manifest.Config.ModifierDelim)
-	// Create regex from var and modifier names
-	// Example: "x_(name|port)((?:\|(?:titlecase|lowercase))*)_"
-	regexpStr := fmt.Sprintf(`%[1]s(%[2]s)((?:%[3]s(?:%[4]s))*)%[5]s`,
-		openDelim,
-		strings.Join(set.Keys(vars), "|"),
-		modifierDelim,
-		strings.Join(set.Keys(Modifiers), "|"),
-		closeDelim,
-	)
-	matcher, err := regexp.Compile(regexpStr)
+	matcher, err := varRefMatcher(manifest, set.Keys(vars))
 	if err != nil {
 		return nil, err
 	}
@@ -170,3 +184,436 @@ func RegexpLoopReplacer(manifest *config.Manifest, vars map[string]string) (func
 		return s
 	}, nil
 }
+
+// varRefMatcher returns a regexp matching references to the given vars using
+// the manifest's delimiters, e.g. "x_(name|port)((?:\|(?:titlecase|lowercase))*)_".
+// The first submatch is the var name, and the second is the modifier chain.
+func varRefMatcher(manifest *config.Manifest, varNames []string) (*regexp.Regexp, error) {
+	quotedNames := make([]string, 0, len(varNames))
+	for _, varName := range varNames {
+		quotedNames = append(quotedNames, regexp.QuoteMeta(varName))
+	}
+	// Sort longer names first, so that a var whose name is a prefix of another's
+	// doesn't prevent the longer name from matching
+	sort.Slice(quotedNames, func(i, j int) bool {
+		return len(quotedNames[i]) > len(quotedNames[j])
+	})
+	namePattern := strings.Join(quotedNames, "|")
+	if len(quotedNames) == 0 {
+		// An empty alternation would match an empty var name between delimiters
+		namePattern = `[^\s\S]`
+	}
+	return regexp.Compile(fmt.Sprintf(`%[1]s(%[2]s)((?:%[3]s(?:%[4]s))*)%[5]s`,
+		regexp.QuoteMeta(manifest.Config.OpenDelim),
+		namePattern,
+		regexp.QuoteMeta(manifest.Config.ModifierDelim),
+		strings.Join(set.Keys(Modifiers), "|"),
+		regexp.QuoteMeta(manifest.Config.CloseDelim),
+	))
+}
+
+// referencedVars returns the names of the given vars that are referenced in s.
+func referencedVars(manifest *config.Manifest, varNames []string, s string) ([]string, error) {
+	matcher, err := varRefMatcher(manifest, varNames)
+	if err != nil {
+		return nil, err
+	}
+	found := set.New[string]()
+	for _, submatch := range matcher.FindAllStringSubmatch(s, -1) {
+		found.Add(submatch[1])
+	}
+	names := set.Keys(found)
+	sort.Strings(names)
+	return names, nil
+}
+
+// Template renders scaffold files and paths with the values of a scaffold's
+// vars. Besides var replacement, file contents may contain block directives,
+// each on a line of its own, wrapped in the manifest's delimiters:
+//
+//	x_if use_db == true_
+//	  db: postgres
+//	x_else_
+//	  db: sqlite
+//	x_end_
+//
+// A for block renders its lines once per item of a list var, with the item
+// bound to a var of its own:
+//
+//	x_for svc in services_
+//	  x_svc_: ./cmd/x_svc_
+//	x_end_
+//
+// Directive lines are never rendered themselves. Directives must be enabled
+// with the manifest's directives setting, and a line that would otherwise be a
+// directive is rendered as text by following the opening delimiter with a
+// backslash, e.g. "x_\end_" is rendered as "x_end_".
+type Template struct {
+	manifest *config.Manifest
+	vars     map[string]string
+	replace  func(string) string
+}
+
+// NewTemplate returns a template that renders with the given var values.
+func NewTemplate(manifest *config.Manifest, vars map[string]string) (*Template, error) {
+	replacer, err := RegexpLoopReplacer(manifest, vars)
+	if err != nil {
+		return nil, err
+	}
+	return &Template{
+		manifest: manifest,
+		vars:     vars,
+		replace:  replacer,
+	}, nil
+}
+
+// Replace performs var replacement on a string, such as a file path.
+func (t *Template) Replace(s string) string {
+	return t.replace(s)
+}
+
+// bind returns a copy of the template with extra vars bound to the given
+// values, such as the items of list vars.
+func (t *Template) bind(extraVars map[string]string) (*Template, error) {
+	if len(extraVars) == 0 {
+		return t, nil
+	}
+	vars := make(map[string]string, len(t.vars)+len(extraVars))
+	for k, v := range t.vars {
+		vars[k] = v
+	}
+	for k, v := range extraVars {
+		vars[k] = v
+	}
+	return NewTemplate(t.manifest, vars)
+}
+
+// isVerbatim reports whether a scaffold file matches one of the manifest's
+// binary patterns, and so should be copied without template replacement.
+func (t *Template) isVerbatim(relativePath string) bool {
+	relativePath = strings.TrimPrefix(relativePath, "/")
+	for _, pattern := range t.manifest.Config.Binary {
+		if glob.MatchPath(pattern, relativePath) {
+			return true
+		}
+	}
+	return false
+}
+
+// fileTarget is an output file rendered from a scaffold file.
+type fileTarget struct {
+	ScaffoldFile
+	// items holds the item vars the file's path refers to, and their values
+	items map[string]string
+	tmpl  *Template
+}
+
+// targets returns the output files to render from the given scaffold files.
+// A scaffold file whose path refers to the item var of a list var is rendered
+// once per item of the list, and other files are rendered once.
+func (t *Template) targets(scaffoldFiles []ScaffoldFile) ([]fileTarget, error) {
+	listNames := []string{}
+	for varName, varOptions := range t.manifest.Vars {
+		if varOptions.Type == config.VarTypeList && varOptions.Item != "" {
+			listNames = append(listNames, varName)
+		}
+	}
+	sort.Strings(listNames)
+
+	targets := make([]fileTarget, 0, len(scaffoldFiles))
+	for _, scaffoldFile := range scaffoldFiles {
+		// Start with a single binding of no items, and fan out for each list
+		// whose item is referred to
+		bindings := []map[string]string{{}}
+		for _, listName := range listNames {
+			item := t.manifest.Vars[listName].Item
+			refs, err := referencedVars(t.manifest, []string{item}, scaffoldFile.RelativePath)
+			if err != nil {
+				return nil, err
+			}
+			if len(refs) == 0 {
+				continue
+			}
+			fannedOut := []map[string]string{}
+			for _, binding := range bindings {
+				for _, value := range config.SplitList(t.vars[listName]) {
+					items := map[string]string{item: value}
+					for k, v := range binding {
+						items[k] = v
+					}
+					fannedOut = append(fannedOut, items)
+				}
+			}
+			bindings = fannedOut
+		}
+
+		for _, items := range bindings {
+			tmpl, err := t.bind(items)
+			if err != nil {
+				return nil, err
+			}
+			targets = append(targets, fileTarget{
+				ScaffoldFile: scaffoldFile,
+				items:        items,
+				tmpl:         tmpl,
+			})
+		}
+	}
+	return targets, nil
+}
+
+// directive returns the keyword and argument of a directive line, e.g. "if" and
+// "use_db == true" for "x_if use_db == true_". Directives are disabled unless
+// the manifest enables them, and if it has neither an opening nor a closing
+// delimiter.
+func (t *Template) directive(line string) (keyword, arg string, ok bool) {
+	openDelim := t.manifest.Config.OpenDelim
+	closeDelim := t.manifest.Config.CloseDelim
+	if !t.manifest.Config.Directives || (openDelim == "" && closeDelim == "") {
+		return "", "", false
+	}
+	line = strings.TrimSpace(line)
+	if len(line) < len(openDelim)+len(closeDelim) || !strings.HasPrefix(line, openDelim) || !strings.HasSuffix(line, closeDelim) {
+		return "", "", false
+	}
+	inner := strings.TrimSpace(line[len(openDelim) : len(line)-len(closeDelim)])
+	keyword, arg, _ = strings.Cut(inner, " ")
+	arg = strings.TrimSpace(arg)
+	switch {
+	case keyword == "if" && arg != "":
+		return keyword, arg, true
+	case keyword == "else" && arg == "":
+		return keyword, "", true
+	case keyword == "else" && strings.HasPrefix(arg, "if "):
+		return "else if", strings.TrimSpace(strings.TrimPrefix(arg, "if ")), true
+	case keyword == "end" && arg == "":
+		return keyword, "", true
+	case keyword == "for" && arg != "":
+		return keyword, arg, true
+	}
+	return "", "", false
+}
+
+// unescapeDirective returns line without the backslash following its opening
+// delimiter, if line is an escaped directive such as "x_\end_", and reports
+// whether it was.
+func (t *Template) unescapeDirective(line string) (string, bool) {
+	trimmed := strings.TrimLeft(line, " \t")
+	escape := t.manifest.Config.OpenDelim + `\`
+	if !strings.HasPrefix(trimmed, escape) {
+		return line, false
+	}
+	indent := line[:len(line)-len(trimmed)]
+	unescaped := indent + t.manifest.Config.OpenDelim + trimmed[len(escape):]
+	if _, _, ok := t.directive(unescaped); !ok {
+		return line, false
+	}
+	return unescaped, true
+}
+
+// templateNode is a part of a template file: a line of text, or a block.
+type templateNode interface {
+	render(t *Template, w io.Writer) error
+}
+
+// textLine is a line of a template file, rendered with var replacement. Its
+// line ending is kept separately, so that it is written back unchanged.
+type textLine struct {
+	text   string
+	ending string
+}
+
+func (l *textLine) render(t *Template, w io.Writer) error {
+	_, err := io.WriteString(w, t.replace(l.text)+l.ending)
+	return err
+}
+
+// ifBlock is a block of lines that is only rendered if its condition is true,
+// along with any else branches.
+type ifBlock struct {
+	line     int
+	branches []*ifBranch
+	hasElse  bool
+}
+
+type ifBranch struct {
+	line int
+	// cond is nil for an else branch
+	cond *expr.Expr
+	body []templateNode
+}
+
+func (b *ifBlock) render(t *Template, w io.Writer) error {
+	for _, branch := range b.branches {
+		if branch.cond != nil {
+			ok, err := branch.cond.EvalBool(t.vars, Modifiers)
+			if err != nil {
+				return fmt.Errorf("line %d: %w", branch.line, err)
+			}
+			if !ok {
+				continue
+			}
+		}
+		for _, node := range branch.body {
+			if err := node.render(t, w); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return nil
+}
+
+func (b *ifBlock) add(node templateNode) {
+	branch := b.branches[len(b.branches)-1]
+	branch.body = append(branch.body, node)
+}
+
+func (b *ifBlock) keyword() string {
+	return "if"
+}
+
+func (b *ifBlock) startLine() int {
+	return b.line
+}
+
+// forBlock is a block of lines that is rendered once for each item of a list
+// var, with the item bound to a var named by the block.
+type forBlock struct {
+	line int
+	item string
+	list string
+	body []templateNode
+}
+
+func (b *forBlock) render(t *Template, w io.Writer) error {
+	value, ok := t.vars[b.list]
+	if !ok {
+		return fmt.Errorf("line %d: undefined var %s", b.line, b.list)
+	}
+	for _, item := range config.SplitList(value) {
+		itemTmpl, err := t.bind(map[string]string{b.item: item})
+		if err != nil {
+			return err
+		}
+		for _, node := range b.body {
+			if err := node.render(itemTmpl, w); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (b *forBlock) add(node templateNode) {
+	b.body = append(b.body, node)
+}
+
+func (b *forBlock) keyword() string {
+	return "for"
+}
+
+func (b *forBlock) startLine() int {
+	return b.line
+}
+
+// block is a template node that contains other nodes.
+type block interface {
+	templateNode
+	add(node templateNode)
+	keyword() string
+	startLine() int
+}
+
+// templateParser splits a template file into nodes, one line at a time.
+// Lines outside of blocks are returned as soon as they are read, so that files
+// without blocks can be rendered as a stream.
+type templateParser struct {
+	tmpl *Template
+	// open holds the blocks that have been started but not ended, innermost last
+	open []block
+}
+
+// feed parses the next line of the file, given without its line ending. It
+// returns a node when one is complete and outside of any block, or nil.
+// Directive lines are dropped along with their line endings.
+func (p *templateParser) feed(line, ending string, lineNum int) (templateNode, error) {
+	keyword, arg, ok := p.tmpl.directive(line)
+	if !ok {
+		line, _ = p.tmpl.unescapeDirective(line)
+		return p.add(&textLine{text: line, ending: ending}), nil
+	}
+
+	switch keyword {
+	case "if":
+		cond, err := expr.Parse(arg, p.tmpl.manifest.Config.ModifierDelim)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", lineNum, err)
+		}
+		p.open = append(p.open, &ifBlock{
+			line:     lineNum,
+			branches: []*ifBranch{{line: lineNum, cond: cond}},
+		})
+		return nil, nil
+	case "for":
+		item, list, ok := strings.Cut(arg, " in ")
+		item, list = strings.TrimSpace(item), strings.TrimSpace(list)
+		if !ok || item == "" || list == "" || strings.ContainsAny(item+list, " \t") {
+			return nil, fmt.Errorf("line %d: for must be given as \"for item in list\"", lineNum)
+		}
+		p.open = append(p.open, &forBlock{
+			line: lineNum,
+			item: item,
+			list: list,
+		})
+		return nil, nil
+	case "else", "else if":
+		var block *ifBlock
+		if len(p.open) > 0 {
+			block, _ = p.open[len(p.open)-1].(*ifBlock)
+		}
+		if block == nil {
+			return nil, fmt.Errorf("line %d: %s without if", lineNum, keyword)
+		}
+		if block.hasElse {
+			return nil, fmt.Errorf("line %d: %s after else", lineNum, keyword)
+		}
+		branch := &ifBranch{line: lineNum}
+		if keyword == "else if" {
+			cond, err := expr.Parse(arg, p.tmpl.manifest.Config.ModifierDelim)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", lineNum, err)
+			}
+			branch.cond = cond
+		} else {
+			block.hasElse = true
+		}
+		block.branches = append(block.branches, branch)
+		return nil, nil
+	default: // "end"
+		if len(p.open) == 0 {
+			return nil, fmt.Errorf("line %d: end without if or for", lineNum)
+		}
+		block := p.open[len(p.open)-1]
+		p.open = p.open[:len(p.open)-1]
+		return p.add(block), nil
+	}
+}
+
+// add adds a node to the innermost open block, or returns it if there is none.
+func (p *templateParser) add(node templateNode) templateNode {
+	if len(p.open) == 0 {
+		return node
+	}
+	p.open[len(p.open)-1].add(node)
+	return nil
+}
+
+// finish returns an error if any blocks were not ended.
+func (p *templateParser) finish() error {
+	if len(p.open) > 0 {
+		innermost := p.open[len(p.open)-1]
+		return fmt.Errorf("line %d: %s without end", innermost.startLine(), innermost.keyword())
+	}
+	return nil
+}
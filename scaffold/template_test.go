@@ -1,6 +1,10 @@
 package scaffold_test
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
 	"testing"
 
 	"github.com/olafal0/rescaffold/assert"
@@ -21,6 +25,7 @@ func testMakeManifest() *config.Manifest {
 			OpenDelim:     "x_",
 			CloseDelim:    "_",
 			ModifierDelim: "|",
+			Directives:    true,
 		},
 		Vars: make(map[string]*config.ManifestVar, len(Vars)),
 	}
@@ -94,3 +99,206 @@ func BenchmarkRegexpLoopReplacer(b *testing.B) {
 		replacer("This is a string without any replacement")
 	}
 }
+
+func TestCaseModifiers(t *testing.T) {
+	assert.Equal(t, scaffold.Modifiers["snakecase"]("MyApp"), "my_app")
+	assert.Equal(t, scaffold.Modifiers["snakecase"]("my app-name"), "my_app_name")
+	assert.Equal(t, scaffold.Modifiers["snakecase"]("HTTPServer2"), "http_server2")
+	assert.Equal(t, scaffold.Modifiers["kebabcase"]("MyApp"), "my-app")
+}
+
+func TestDeriveVars(t *testing.T) {
+	manifest := testMakeManifest()
+	manifest.Vars["module_path"] = &config.ManifestVar{Type: "string", Expr: `"github.com/acme/" + name|lowercase`}
+	manifest.Vars["db_name"] = &config.ManifestVar{Type: "string", Expr: `name|snakecase + "_db"`}
+	manifest.Vars["dsn"] = &config.ManifestVar{Type: "string", Template: "postgres://localhost:x_port_/x_db_name_"}
+
+	varValues := map[string]string{"name": "MyApp", "port": "8080"}
+	err := scaffold.DeriveVars(manifest, varValues)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, varValues["module_path"], "github.com/acme/myapp")
+	assert.Equal(t, varValues["db_name"], "my_app_db")
+	assert.Equal(t, varValues["dsn"], "postgres://localhost:8080/my_app_db")
+
+	manifest.Vars["a"] = &config.ManifestVar{Type: "string", Expr: `b + "x"`}
+	manifest.Vars["b"] = &config.ManifestVar{Type: "string", Template: "x_a_"}
+	err = scaffold.DeriveVars(manifest, varValues)
+	if err == nil {
+		t.Fatal("expected cycle error")
+	}
+	assert.Equal(t, err.Error(), "derived vars depend on each other in a cycle: a -> b -> a")
+}
+
+func testRender(t *testing.T, vars map[string]string, src string) (string, error) {
+	manifest := testMakeManifest()
+	tmpl, err := scaffold.NewTemplate(manifest, vars)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := &bytes.Buffer{}
+	_, err = scaffold.ApplyTemplate(strings.NewReader(src), buf, tmpl)
+	return buf.String(), err
+}
+
+func TestIfBlocks(t *testing.T) {
+	src := `services:
+  x_name|lowercase_:
+    port: x_port_
+x_if port == 8080_
+  proxy:
+    x_if name == "MyApp"_
+    name: x_name_
+    x_else_
+    name: other
+    x_end_
+x_else if port == 80_
+  http: true
+x_else_
+  custom: x_port_
+x_end_
+done
+`
+	out, err := testRender(t, Vars, src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, out, `services:
+  myapp:
+    port: 8080
+  proxy:
+    name: MyApp
+done
+`)
+
+	out, err = testRender(t, map[string]string{"name": "Other", "port": "80"}, src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, out, `services:
+  other:
+    port: 80
+  http: true
+done
+`)
+
+	out, err = testRender(t, map[string]string{"name": "Other", "port": "9000"}, src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.StrContains(t, out, "  custom: 9000\n")
+	assert.StrNotContains(t, out, "proxy")
+}
+
+func TestIfBlockErrors(t *testing.T) {
+	for src, expected := range map[string]string{
+		"x_if port == 8080_\nfoo\n":              "line 1: if without end",
+		"foo\nx_end_\n":                          "line 2: end without if",
+		"x_else_\n":                              "line 1: else without if",
+		"x_if true_\nx_else_\nx_else_\nx_end_\n": "line 3: else after else",
+		"x_if missing == 1_\nx_end_\n":           "line 1: undefined var missing",
+		"x_if port ==_\nx_end_\n":                "line 1: invalid expression",
+	} {
+		_, err := testRender(t, Vars, src)
+		if err == nil {
+			t.Errorf("expected error rendering %q", src)
+			continue
+		}
+		assert.StrContains(t, err.Error(), expected)
+	}
+}
+
+func TestForBlocks(t *testing.T) {
+	vars := map[string]string{"name": "MyApp", "port": "8080", "services": "api,worker"}
+	src := `x_for svc in services_
+x_svc_:
+  image: x_name|lowercase_-x_svc_
+x_if svc == "api"_
+  port: x_port_
+x_end_
+x_end_
+`
+	out, err := testRender(t, vars, src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, out, `api:
+  image: myapp-api
+  port: 8080
+worker:
+  image: myapp-worker
+`)
+
+	_, err = testRender(t, vars, "x_for svc in missing_\nx_end_\n")
+	assert.StrContains(t, err.Error(), "line 1: undefined var missing")
+	_, err = testRender(t, vars, "x_for svc services_\nx_end_\n")
+	assert.StrContains(t, err.Error(), "line 1: for must be given as")
+	_, err = testRender(t, vars, "x_for svc in services_\nx_else_\nx_end_\n")
+	assert.StrContains(t, err.Error(), "line 2: else without if")
+	_, err = testRender(t, vars, "x_for svc in services_\n")
+	assert.StrContains(t, err.Error(), "line 1: for without end")
+}
+
+func TestDirectivesDisabled(t *testing.T) {
+	// Go templates use the same delimiters, and their directives must be left
+	// alone unless the scaffold enables its own
+	src := "{{if .Ready}}\n{{name}}\n{{else}}\n{{end}}\n{{for x in y}}\n"
+	manifest := &config.Manifest{
+		Config: &config.ManifestConfig{OpenDelim: "{{", CloseDelim: "}}"},
+		Vars:   map[string]*config.ManifestVar{"name": {}},
+	}
+	tmpl, err := scaffold.NewTemplate(manifest, map[string]string{"name": "MyApp"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := &bytes.Buffer{}
+	if _, err := scaffold.ApplyTemplate(strings.NewReader(src), buf, tmpl); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, buf.String(), "{{if .Ready}}\nMyApp\n{{else}}\n{{end}}\n{{for x in y}}\n")
+}
+
+func TestEscapedDirectives(t *testing.T) {
+	src := "x_if port == 8080_\n  x_\\else_\n  x_\\end_\nx_end_\nx_\\if port == 80_\nx_\\other_\n"
+	out, err := testRender(t, Vars, src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, out, "  x_else_\n  x_end_\nx_if port == 80_\nx_\\other_\n")
+}
+
+func TestApplyTemplateBytes(t *testing.T) {
+	manifest := testMakeManifest()
+	tmpl, err := scaffold.NewTemplate(manifest, Vars)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Longer than bufio.Scanner's default maximum token size
+	longLine := strings.Repeat("a", 100000)
+	cases := map[string]string{
+		"crlf":       "name: x_name_\r\nx_if port == 8080_\r\nport: x_port_\r\nx_end_\r\n",
+		"no newline": "name: x_name_\nport: x_port_",
+		"long line":  longLine + " x_name_\n",
+		"binary":     "\x89PNG\r\n\x1a\n\x00x_name_\n",
+		"empty":      "",
+	}
+	expected := map[string]string{
+		"crlf":       "name: MyApp\r\nport: 8080\r\n",
+		"no newline": "name: MyApp\nport: 8080",
+		"long line":  longLine + " MyApp\n",
+		"binary":     cases["binary"],
+		"empty":      "",
+	}
+	for name, src := range cases {
+		buf := &bytes.Buffer{}
+		checksum, err := scaffold.ApplyTemplate(strings.NewReader(src), buf, tmpl)
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		assert.Equal(t, buf.String(), expected[name])
+		sum := sha256.Sum256(buf.Bytes())
+		assert.Equal(t, checksum, hex.EncodeToString(sum[:]))
+	}
+}
@@ -0,0 +1,197 @@
+package scaffold
+
+import (
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// transaction stages writes and deletions of files, then commits them together.
+// If committing fails partway through, every change already made is reverted,
+// leaving the files as they were before the transaction began.
+type transaction struct {
+	// dir is the staging directory, which holds new file contents until they are
+	// committed, and backups of replaced or deleted files until the transaction
+	// is finished
+	dir string
+	ops []*txOp
+	// done records the operations that have been committed so far, so that
+	// they can be rolled back in reverse order
+	done []*txOp
+	// createdDirs records directories created while committing
+	createdDirs []string
+	// incomplete is set if rollback failed, in which case the staging directory
+	// may hold backups that could not be restored
+	incomplete bool
+}
+
+type txOp struct {
+	path string
+	// staged is the path of the new content in the staging directory, or empty
+	// if this operation deletes path
+	staged string
+	// backup is the path that the original file was moved to, or empty if there
+	// was no original file
+	backup string
+}
+
+// stagingDirPrefix is the prefix of the names of staging directories.
+const stagingDirPrefix = ".rescaffold-tx-"
+
+// newTransaction creates a transaction that stages files in a temporary
+// directory inside dir. Staging in the same directory tree as the output allows
+// files to be moved into place with a rename.
+func newTransaction(dir string) (*transaction, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	stagingDir, err := os.MkdirTemp(dir, stagingDirPrefix)
+	if err != nil {
+		return nil, fmt.Errorf("error creating staging directory: %w", err)
+	}
+	return &transaction{dir: stagingDir}, nil
+}
+
+// write stages content to be written to filename with the given mode when the
+// transaction is committed. If mode is a symlink, content is the link target.
+func (tx *transaction) write(filename string, content []byte, mode fs.FileMode) error {
+	staged := filepath.Join(tx.dir, fmt.Sprintf("staged-%d", len(tx.ops)))
+	var err error
+	if mode&fs.ModeSymlink != 0 {
+		err = os.Symlink(string(content), staged)
+	} else {
+		err = os.WriteFile(staged, content, mode.Perm())
+	}
+	if err != nil {
+		return fmt.Errorf("error staging file: %w", err)
+	}
+	tx.ops = append(tx.ops, &txOp{path: filename, staged: staged})
+	return nil
+}
+
+// remove stages filename to be deleted when the transaction is committed.
+func (tx *transaction) remove(filename string) {
+	tx.ops = append(tx.ops, &txOp{path: filename})
+}
+
+// commit moves all staged changes into place. If any change fails, all
+// previous changes are rolled back and the error is returned.
+func (tx *transaction) commit() error {
+	for i, op := range tx.ops {
+		if err := tx.commitOp(i, op); err != nil {
+			tx.rollback()
+			return err
+		}
+	}
+	return nil
+}
+
+func (tx *transaction) commitOp(i int, op *txOp) error {
+	// Move any existing file out of the way, so it can be restored
+	if _, err := os.Lstat(op.path); err == nil {
+		backup := filepath.Join(tx.dir, fmt.Sprintf("backup-%d", i))
+		if err := os.Rename(op.path, backup); err != nil {
+			return fmt.Errorf("error moving %s aside: %w", op.path, err)
+		}
+		op.backup = backup
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+	tx.done = append(tx.done, op)
+
+	if op.staged == "" {
+		return nil
+	}
+	if err := tx.mkdirAll(path.Dir(op.path)); err != nil {
+		return fmt.Errorf("error creating subdirectories: %w", err)
+	}
+	if err := os.Rename(op.staged, op.path); err != nil {
+		return fmt.Errorf("error writing %s: %w", op.path, err)
+	}
+	return nil
+}
+
+// mkdirAll creates dir and any missing parents, recording each directory
+// created so that it can be removed on rollback.
+func (tx *transaction) mkdirAll(dir string) error {
+	if _, err := os.Stat(dir); err == nil {
+		return nil
+	}
+	if parent := path.Dir(dir); parent != dir {
+		if err := tx.mkdirAll(parent); err != nil {
+			return err
+		}
+	}
+	if err := os.Mkdir(dir, 0755); err != nil {
+		if os.IsExist(err) {
+			return nil
+		}
+		return err
+	}
+	tx.createdDirs = append(tx.createdDirs, dir)
+	return nil
+}
+
+// rollback reverts all committed operations. Failures are logged, since there
+// is nothing more that can be done about them.
+func (tx *transaction) rollback() {
+	for i := len(tx.done) - 1; i >= 0; i-- {
+		op := tx.done[i]
+		if op.staged != "" {
+			if err := os.Remove(op.path); err != nil && !os.IsNotExist(err) {
+				log.Printf("rollback: could not remove %s: %v\n", op.path, err)
+			}
+		}
+		if op.backup != "" {
+			if err := os.Rename(op.backup, op.path); err != nil {
+				log.Printf("rollback: could not restore %s from %s: %v\n", op.path, op.backup, err)
+				tx.incomplete = true
+			}
+		}
+	}
+	for i := len(tx.createdDirs) - 1; i >= 0; i-- {
+		if err := os.Remove(tx.createdDirs[i]); err != nil {
+			log.Printf("rollback: could not remove directory %s: %v\n", tx.createdDirs[i], err)
+		}
+	}
+	tx.done = nil
+	tx.createdDirs = nil
+}
+
+// cleanup removes the staging directory, including any backups. It must be
+// called once the transaction is no longer needed.
+//
+// If rescaffold is killed before cleanup, the staging directory is left
+// behind. It isn't removed by later runs, since it may hold the only copies of
+// files that were being replaced; StaleStagingDirs finds it instead, so that
+// status and lint can warn about it.
+func (tx *transaction) cleanup() {
+	if tx.incomplete {
+		log.Printf("rollback was incomplete, original files are kept in %s\n", tx.dir)
+		return
+	}
+	if err := os.RemoveAll(tx.dir); err != nil {
+		log.Printf("could not remove staging directory %s: %v\n", tx.dir, err)
+	}
+}
+
+// StaleStagingDirs returns the staging directories in dir left behind by
+// transactions that never finished, such as when rescaffold was killed while
+// applying a plan. They may hold backups of files that were being replaced.
+func StaleStagingDirs(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	stale := []string{}
+	for _, entry := range entries {
+		if entry.IsDir() && strings.HasPrefix(entry.Name(), stagingDirPrefix) {
+			stale = append(stale, filepath.Join(dir, entry.Name()))
+		}
+	}
+	return stale, nil
+}
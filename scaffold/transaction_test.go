@@ -0,0 +1,135 @@
+package scaffold
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/olafal0/rescaffold/assert"
+)
+
+func TestTransactionRollback(t *testing.T) {
+	dir := t.TempDir()
+	existing := filepath.Join(dir, "existing.txt")
+	deleted := filepath.Join(dir, "deleted.txt")
+	newFile := filepath.Join(dir, "sub", "dir", "new.txt")
+	// A path whose parent is a regular file can't be written, causing the commit
+	// to fail after the other changes have been made
+	invalid := filepath.Join(existing, "invalid.txt")
+	if err := os.WriteFile(existing, []byte("original"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(deleted, []byte("keep me"), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	tx, err := newTransaction(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.cleanup()
+	if err := tx.write(newFile, []byte("new"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	tx.remove(deleted)
+	if err := tx.write(existing, []byte("changed"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.write(invalid, []byte("fails"), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := tx.commit(); err == nil {
+		t.Fatal("expected commit to fail")
+	}
+
+	data, err := os.ReadFile(existing)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, string(data), "original")
+	data, err = os.ReadFile(deleted)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, string(data), "keep me")
+	_, err = os.Stat(filepath.Join(dir, "sub"))
+	assert.Equal(t, os.IsNotExist(err), true)
+}
+
+func TestTransactionCommit(t *testing.T) {
+	dir := t.TempDir()
+	deleted := filepath.Join(dir, "deleted.txt")
+	newFile := filepath.Join(dir, "sub", "new.txt")
+	if err := os.WriteFile(deleted, []byte("delete me"), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	tx, err := newTransaction(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.write(newFile, []byte("new"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	tx.remove(deleted)
+	if err := tx.commit(); err != nil {
+		t.Fatal(err)
+	}
+	tx.cleanup()
+
+	data, err := os.ReadFile(newFile)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, string(data), "new")
+	_, err = os.Stat(deleted)
+	assert.Equal(t, os.IsNotExist(err), true)
+	entries, err := os.ReadDir(dir)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, len(entries), 1)
+}
+
+func TestTransactionModes(t *testing.T) {
+	dir := t.TempDir()
+	script := filepath.Join(dir, "scripts", "dev.sh")
+	link := filepath.Join(dir, "link.sh")
+
+	tx, err := newTransaction(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.cleanup()
+	if err := tx.write(script, []byte("#!/bin/sh\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.write(link, []byte("scripts/dev.sh"), fs.ModeSymlink|0777); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := os.Stat(script)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, info.Mode().Perm()&0100, 0100)
+	target, err := os.Readlink(link)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, target, "scripts/dev.sh")
+}
+
+func TestStaleStagingDirs(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, stagingDirPrefix+"file"), nil, 0666); err != nil {
+		t.Fatal(err)
+	}
+	tx, err := newTransaction(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A transaction that never finishes leaves its staging directory behind
+	stale, err := StaleStagingDirs(dir)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, len(stale), 1)
+	assert.Equal(t, stale[0], tx.dir)
+
+	tx.cleanup()
+	stale, err = StaleStagingDirs(dir)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, len(stale), 0)
+}
@@ -2,21 +2,40 @@ package scaffold
 
 import (
 	"fmt"
-	"os"
+	"io/fs"
 	"path"
 
 	"github.com/olafal0/rescaffold/config"
+	"github.com/olafal0/rescaffold/diff"
 	"github.com/olafal0/rescaffold/set"
 )
 
-func Upgrade(lockfile *config.Lockfile, scaffoldSource, outdir string) error {
-	scaf, err := LoadScaffold(scaffoldSource)
+// PlanUpgrade plans regenerating the files of a scaffold from its latest
+// version. If ref is empty, the ref recorded in the lockfile (if any) is used;
+// otherwise the scaffold is upgraded to ref, which is recorded for future
+// upgrades.
+func PlanUpgrade(lockfile *config.Lockfile, scaffoldSource, ref, outdir string, opts Options) (*Plan, error) {
+	if ref == "" {
+		if lockedScaffold, ok := lockfile.Scaffolds[scaffoldSource]; ok {
+			ref = lockedScaffold.Ref
+		}
+	}
+	scaf, err := LoadScaffold(scaffoldSource, ref, opts.Offline)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	defer scaf.Cleanup()
 
-	lockedScaffold := lockfile.GetScaffold(scaffoldSource, scaf.Manifest)
+	lockedScaffold := plannedScaffold(lockfile, scaffoldSource)
+	plan := &Plan{
+		Source:     scaffoldSource,
+		FromCommit: lockedScaffold.Commit,
+		ToCommit:   scaf.Commit,
+		outdir:     outdir,
+		locked:     lockedScaffold,
+	}
+	lockedScaffold.Ref = ref
+	lockedScaffold.Commit = scaf.Commit
 
 	// Create a set of output filenames that are present in the lockfile
 	lockedFilePaths := set.NewWithCap[string](len(lockedScaffold.Files))
@@ -24,21 +43,36 @@ func Upgrade(lockfile *config.Lockfile, scaffoldSource, outdir string) error {
 		lockedFilePaths.Add(lockedFile.Path)
 	}
 
+	// Files that have been modified are merged against their previously
+	// generated content, which must be rendered with the previously locked vars
+	previousTmpl, err := NewTemplate(scaf.Manifest, lockedScaffold.Vars)
+	if err != nil {
+		return nil, err
+	}
+
 	// Find all vars in the manifest
 	// If any do not have values in the lockfile, prompt the user for them
-	varValues, err := LoadVarsInteractive(scaf.Manifest.Vars, lockedScaffold.Vars)
+	varValues, err := resolveVars(scaf.Manifest, lockedScaffold.Vars, opts)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	lockedScaffold.Vars = varValues
 
-	replacer, err := RegexpLoopReplacer(scaf.Manifest, varValues)
+	tmpl, err := NewTemplate(scaf.Manifest, varValues)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	for _, scaffoldFile := range scaf.Files {
-		outFilename := replacer(scaffoldFile.RelativePath)
+	scaffoldFiles, err := scaf.IncludedFiles(varValues)
+	if err != nil {
+		return nil, err
+	}
+	targets, err := tmpl.targets(scaffoldFiles)
+	if err != nil {
+		return nil, err
+	}
+	for _, target := range targets {
+		outFilename := target.tmpl.Replace(target.RelativePath)
 		outpath := path.Join(outdir, outFilename)
 
 		// Get file info from lockfile (may be nil)
@@ -48,99 +82,170 @@ func Upgrade(lockfile *config.Lockfile, scaffoldSource, outdir string) error {
 		lockedFilePaths.Remove(outpath)
 
 		// Check if file exists at destination
-		existingOutfile, err := os.Open(outpath)
-		if err != nil && !os.IsNotExist(err) {
-			return fmt.Errorf("error checking if file exists: %w", err)
+		checksum, exists, err := existingChecksum(outpath)
+		if err != nil {
+			return nil, err
 		}
 
-		// If file exists, check that its contents are what we expect (matching checksum)
-		if existingOutfile != nil {
-			if lockedFile == nil {
-				return fmt.Errorf("file already exists but is not in lockfile: %s", outpath)
-			}
+		content, newChecksum, err := renderFile(target.ScaffoldFile, target.tmpl)
+		if err != nil {
+			return nil, err
+		}
 
-			checksum, err := hashFile(existingOutfile)
-			if err != nil {
-				return err
-			}
+		if !exists {
+			plan.add(ActionCreate, outpath, "").setContent(content, target.Mode)
+			trackFile(lockedScaffold, outpath, newChecksum, target.Mode)
+			continue
+		}
 
-			if lockedFile.Checksum != checksum {
-				fmt.Printf("file has been modified, skipping: %s\n", outpath)
+		// File exists, check that its contents are what we expect (matching checksum)
+		if lockedFile == nil {
+			if newChecksum == checksum {
+				plan.add(ActionUnchanged, outpath, "")
+				trackFile(lockedScaffold, outpath, newChecksum, target.Mode)
 				continue
 			}
-
-			// Output file exists and matches expected value. We're upgrading, so this
-			// file may be rewritten
-		} else {
-			// File does not exist; create directories in path so we can open it for writing
-			err = os.MkdirAll(path.Dir(outpath), 0755)
+			current, err := readExisting(outpath)
 			if err != nil {
-				return fmt.Errorf("error creating subdirectories: %w", err)
+				return nil, fmt.Errorf("error reading existing file: %w", err)
 			}
+			err = opts.resolveConflict(plan, lockedScaffold, conflict{
+				path:     outpath,
+				reason:   "file already exists but is not in lockfile",
+				current:  current,
+				rendered: content,
+				checksum: newChecksum,
+				mode:     target.Mode,
+			})
+			if err != nil {
+				return nil, err
+			}
+			continue
 		}
 
-		outfile, err := os.Create(outpath)
-		if err != nil {
-			return fmt.Errorf("error creating file: %w", err)
-		}
-
-		// Stream file from input -> template replacement -> output
-		sourceFile, err := os.Open(scaffoldFile.FullPath)
-		if err != nil {
-			return fmt.Errorf("error opening source file: %w", err)
+		if lockedFile.Checksum != checksum {
+			if err := planMerge(plan, scaf, target, outpath, lockedFile, previousTmpl, content, newChecksum, opts); err != nil {
+				return nil, err
+			}
+			continue
 		}
-		defer sourceFile.Close()
 
-		newChecksum, err := ApplyTemplate(sourceFile, outfile, replacer)
-		if err != nil {
-			return fmt.Errorf("error applying template: %w", err)
+		// Output file exists and matches expected value. We're upgrading, so this
+		// file may be rewritten
+		if newChecksum == checksum {
+			if lockedFile.FileMode() == target.Mode {
+				plan.add(ActionUnchanged, outpath, "")
+				continue
+			}
+			// Rewrite the file to apply its new mode
+			plan.add(ActionOverwrite, outpath, fmt.Sprintf("mode changed to %04o", target.Mode.Perm())).setContent(content, target.Mode)
+			lockedFile.SetMode(target.Mode)
+			continue
 		}
+		plan.add(ActionOverwrite, outpath, "").setContent(content, target.Mode)
+		lockedFile.Checksum = newChecksum
+		lockedFile.SetMode(target.Mode)
+	}
 
-		// Update lockfile with new file information for each new file
-		lockedScaffold.SetFile(outpath, newChecksum)
-		if err := lockfile.WriteUpdated(); err != nil {
-			return fmt.Errorf("error writing updated lockfile: %w", err)
+	// Remove files that were present in the lockfile but not in the updated
+	// scaffold, or whose conditions are no longer met
+	for _, lockedFile := range lockedScaffold.Files {
+		lockedFilePath := lockedFile.Path
+		if !lockedFilePaths.Contains(lockedFilePath) {
+			continue
 		}
-	}
 
-	// Remove files that were present in the lockfile but not in the updated scaffold
-	for lockedFilePath := range lockedFilePaths {
-		lockedFile := lockedScaffold.GetFile(lockedFilePath)
 		// Check that the file exists and checksum matches
-		f, err := os.Open(lockedFilePath)
-		if err != nil && !os.IsNotExist(err) {
-			return fmt.Errorf("file present in lockfile, but could not open: %w", err)
+		checksum, exists, err := existingChecksum(lockedFilePath)
+		if err != nil {
+			return nil, fmt.Errorf("file present in lockfile, but could not open: %w", err)
 		}
-		if f == nil {
+		switch {
+		case !exists:
 			// File does not exist, so it's already been deleted
-			lockedScaffold.RemoveFile(lockedFilePath)
-			if err := lockfile.WriteUpdated(); err != nil {
-				return fmt.Errorf("error writing updated lockfile: %w", err)
-			}
-			continue
+			plan.add(ActionUntrack, lockedFilePath, "")
+		case lockedFile.Checksum != checksum:
+			// Leave the modified file in place but stop tracking it in the lockfile
+			plan.add(ActionUntrack, lockedFilePath, "file should be deleted by upgrade, but has been modified - leaving in place")
+		default:
+			// Checksum matches, so the file exists and is unmodified, but the upgrade
+			// removes it. Delete the file and remove it from the lockfile.
+			plan.add(ActionDelete, lockedFilePath, "")
 		}
+	}
+	for lockedFilePath := range lockedFilePaths {
+		lockedScaffold.RemoveFile(lockedFilePath)
+	}
+	return plan, nil
+}
 
-		checksum, err := hashFile(f)
-		if err != nil {
-			return err
-		}
+// planMerge plans the update of a file that has been modified since it was
+// generated, by performing a three-way merge between the previously generated
+// content, the current file, and the newly rendered content.
+func planMerge(plan *Plan, scaf *Scaffold, target fileTarget, outpath string, lockedFile *config.LockfileScaffoldFile, previousTmpl *Template, rendered []byte, newChecksum string, opts Options) error {
+	if newChecksum == lockedFile.Checksum {
+		// The scaffold has not changed this file, so there is nothing to merge
+		plan.add(ActionSkipModified, outpath, "")
+		return nil
+	}
 
-		if lockedFile.Checksum != checksum {
-			fmt.Printf("file should be deleted by upgrade, but has been modified - leaving in place: %s\n", lockedFilePath)
-			// Leave the modified file in place but stop tracking it in the lockfile
-			lockedScaffold.RemoveFile(lockedFilePath)
-			if err := lockfile.WriteUpdated(); err != nil {
-				return fmt.Errorf("error writing updated lockfile: %w", err)
-			}
+	current, err := readExisting(outpath)
+	if err != nil {
+		return fmt.Errorf("error reading modified file: %w", err)
+	}
 
-			continue
+	if target.Mode&fs.ModeSymlink != 0 || isBinary(current) || isBinary(rendered) {
+		reason := "binary file has been modified"
+		if target.Mode&fs.ModeSymlink != 0 {
+			reason = "symlink has been modified"
 		}
+		return opts.resolveConflict(plan, plan.locked, conflict{
+			path:     outpath,
+			reason:   reason,
+			tracked:  true,
+			current:  current,
+			rendered: rendered,
+			checksum: newChecksum,
+			mode:     target.Mode,
+		})
+	}
 
-		// Checksum matches, so the file exists and is unmodified, but the upgrade
-		// removes it. Delete the file and remove it from the lockfile.
-		if err := os.Remove(lockedFilePath); err != nil {
-			return fmt.Errorf("error deleting file: %w", err)
+	// Files generated once per list item are rendered with the same item
+	previousTmpl, err = previousTmpl.bind(target.items)
+	if err != nil {
+		return err
+	}
+	base := findPreviousRender(scaf, target.ScaffoldFile, plan.FromCommit, previousTmpl, lockedFile.Checksum)
+	if base == nil {
+		reason := "file has been modified and its previously generated content could not be found"
+		if !hasHistory(scaf) {
+			// Local scaffolds that aren't in git have no previous revisions to
+			// merge from
+			reason = "file has been modified and the scaffold has no git history to merge with"
 		}
+		return opts.resolveConflict(plan, plan.locked, conflict{
+			path:     outpath,
+			reason:   reason,
+			tracked:  true,
+			current:  current,
+			rendered: rendered,
+			checksum: newChecksum,
+			mode:     target.Mode,
+		})
 	}
+	merged, conflicts := diff.Merge(base, current, rendered, diff.MergeLabels{
+		Ours:   outpath,
+		Theirs: "scaffold",
+	})
+	detail := "merged local modifications"
+	if conflicts > 0 {
+		detail = fmt.Sprintf("merged with %d conflict(s), resolve before committing", conflicts)
+	}
+	plan.add(ActionMerge, outpath, detail).setContent(merged, target.Mode)
+
+	// The lockfile tracks the generated content, so that the next upgrade can
+	// use it as the merge base again
+	lockedFile.Checksum = newChecksum
+	lockedFile.SetMode(target.Mode)
 	return nil
 }
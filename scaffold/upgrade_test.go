@@ -0,0 +1,213 @@
+package scaffold_test
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/olafal0/rescaffold/assert"
+	"github.com/olafal0/rescaffold/config"
+	"github.com/olafal0/rescaffold/scaffold"
+)
+
+// testGitRepo creates a git repository containing a scaffold with the given
+// files, committed, and returns its directory.
+func testGitRepo(t *testing.T, files map[string]string) string {
+	t.Helper()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	dir := t.TempDir()
+	testRunGit(t, dir, "init", "--quiet")
+	files[config.ManifestFilename] = testManifest
+	testCommit(t, dir, files)
+	return dir
+}
+
+// testCommit writes files to the repository in dir, and commits them.
+func testCommit(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	testWriteFiles(t, dir, files)
+	testRunGit(t, dir, "add", ".")
+	testRunGit(t, dir, "commit", "--quiet", "-m", "update")
+}
+
+// testRunGit runs git in dir, failing the test on error.
+func testRunGit(t *testing.T, dir string, args ...string) string {
+	t.Helper()
+	args = append([]string{"-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("git %s: %v\n%s", strings.Join(args, " "), err, out)
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// testPrune deletes unreachable commits from the repository in dir, as they
+// would be after a force push.
+func testPrune(t *testing.T, dir string) {
+	t.Helper()
+	testRunGit(t, dir, "reflog", "expire", "--expire=now", "--all")
+	testRunGit(t, dir, "gc", "--quiet", "--prune=now")
+}
+
+// testUpgrade plans and applies an upgrade of the scaffold in outdir, and
+// returns the upgrade's action on file.txt.
+func testUpgrade(t *testing.T, lockfile *config.Lockfile, source, ref, outdir string) *scaffold.Action {
+	t.Helper()
+	plan, err := scaffold.PlanUpgrade(lockfile, source, ref, outdir, scaffold.Options{NonInteractive: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var fileAction *scaffold.Action
+	for _, action := range plan.Actions {
+		if filepath.Base(action.Path) == "file.txt" {
+			fileAction = action
+		}
+	}
+	if fileAction == nil {
+		t.Fatal("upgrade has no action for file.txt")
+	}
+	if err := scaffold.Apply(lockfile, plan); err != nil {
+		t.Fatal(err)
+	}
+	return fileAction
+}
+
+const testMergeBase = "one\ntwo\nthree\nfour\nfive\n"
+
+func TestUpgradeMerge(t *testing.T) {
+	scaffoldDir := testGitRepo(t, map[string]string{"file.txt": testMergeBase})
+	outdir, lockfile := testGenerate(t, scaffoldDir)
+	outfile := filepath.Join(outdir, "file.txt")
+
+	// The scaffold and the generated file change different lines
+	testCommit(t, scaffoldDir, map[string]string{"file.txt": "ONE\ntwo\nthree\nfour\nfive\n"})
+	testWriteFiles(t, outdir, map[string]string{"file.txt": "one\ntwo\nthree\nfour\nFIVE\n"})
+
+	action := testUpgrade(t, lockfile, scaffoldDir, "", outdir)
+	assert.Equal(t, action.Kind, scaffold.ActionMerge)
+	assert.Equal(t, action.Detail, "merged local modifications")
+	content, err := os.ReadFile(outfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, string(content), "ONE\ntwo\nthree\nfour\nFIVE\n")
+
+	// The lockfile tracks the scaffold's content, so the file stays modified
+	// and the next upgrade merges from it again
+	statuses, err := scaffold.Status(lockfile, outdir, scaffold.StatusOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, statuses[0].Files[0].State, scaffold.FileModified)
+	testCommit(t, scaffoldDir, map[string]string{"file.txt": "ONE\nTWO\nthree\nfour\nfive\n"})
+	action = testUpgrade(t, lockfile, scaffoldDir, "", outdir)
+	assert.Equal(t, action.Kind, scaffold.ActionMerge)
+	content, err = os.ReadFile(outfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, string(content), "ONE\nTWO\nthree\nfour\nFIVE\n")
+}
+
+func TestUpgradeMergeConflict(t *testing.T) {
+	scaffoldDir := testGitRepo(t, map[string]string{"file.txt": testMergeBase})
+	outdir, lockfile := testGenerate(t, scaffoldDir)
+
+	// The scaffold and the generated file change the same line
+	testCommit(t, scaffoldDir, map[string]string{"file.txt": "one\ntwo\nscaffold\nfour\nfive\n"})
+	testWriteFiles(t, outdir, map[string]string{"file.txt": "one\ntwo\nlocal\nfour\nfive\n"})
+
+	action := testUpgrade(t, lockfile, scaffoldDir, "", outdir)
+	assert.Equal(t, action.Kind, scaffold.ActionMerge)
+	assert.StrContains(t, action.Detail, "1 conflict(s)")
+	content, err := os.ReadFile(filepath.Join(outdir, "file.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.StrContains(t, string(content), "one\ntwo\n<<<<<<< ")
+	assert.StrContains(t, string(content), "local\n=======\nscaffold\n>>>>>>> scaffold\nfour\nfive\n")
+}
+
+func TestUpgradeMergeMissingCommit(t *testing.T) {
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+	scaffoldDir := testGitRepo(t, map[string]string{"file.txt": testMergeBase})
+	branch := testRunGit(t, scaffoldDir, "symbolic-ref", "--short", "HEAD")
+
+	outdir := t.TempDir()
+	lockfile, err := config.LoadLockfile(filepath.Join(outdir, config.LockfileFilename))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lockfile.Close()
+	plan, err := scaffold.PlanGenerate(lockfile, scaffoldDir, branch, outdir, scaffold.Options{NonInteractive: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := scaffold.Apply(lockfile, plan); err != nil {
+		t.Fatal(err)
+	}
+	lockedCommit := lockfile.Scaffolds[scaffoldDir].Commit
+
+	// Rewriting the branch's history removes the locked commit from it, but the
+	// file's previous content can still be found in the rewritten history
+	testRunGit(t, scaffoldDir, "commit", "--quiet", "--amend", "-m", "rewritten")
+	if testRunGit(t, scaffoldDir, "rev-parse", "HEAD") == lockedCommit {
+		t.Fatal("expected the amended commit to differ from the locked commit")
+	}
+	testPrune(t, scaffoldDir)
+	testCommit(t, scaffoldDir, map[string]string{"file.txt": "ONE\ntwo\nthree\nfour\nfive\n"})
+	testWriteFiles(t, outdir, map[string]string{"file.txt": "one\ntwo\nthree\nfour\nFIVE\n"})
+
+	action := testUpgrade(t, lockfile, scaffoldDir, branch, outdir)
+	assert.Equal(t, action.Kind, scaffold.ActionMerge)
+	content, err := os.ReadFile(filepath.Join(outdir, "file.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, string(content), "ONE\ntwo\nthree\nfour\nFIVE\n")
+
+	// If the previous content isn't in the history at all, the file can't be
+	// merged, and is a conflict instead
+	testRunGit(t, scaffoldDir, "checkout", "--quiet", "--orphan", "replaced")
+	testCommit(t, scaffoldDir, map[string]string{"file.txt": "replaced\n"})
+	testRunGit(t, scaffoldDir, "checkout", "--quiet", "-B", branch)
+	testRunGit(t, scaffoldDir, "branch", "--quiet", "-D", "replaced")
+	testPrune(t, scaffoldDir)
+	testWriteFiles(t, outdir, map[string]string{"file.txt": "modified again\n"})
+	plan, err = scaffold.PlanUpgrade(lockfile, scaffoldDir, branch, outdir, scaffold.Options{
+		Conflict:       scaffold.ConflictOurs,
+		NonInteractive: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, testActions(plan)["file.txt"], scaffold.ActionSkipModified)
+}
+
+func TestUpgradeNoHistory(t *testing.T) {
+	scaffoldDir := t.TempDir()
+	testWriteFiles(t, scaffoldDir, map[string]string{
+		config.ManifestFilename: testManifest,
+		"file.txt":              testMergeBase,
+	})
+	outdir, lockfile := testGenerate(t, scaffoldDir)
+
+	testWriteFiles(t, scaffoldDir, map[string]string{"file.txt": "ONE\ntwo\nthree\nfour\nfive\n"})
+	testWriteFiles(t, outdir, map[string]string{"file.txt": "one\ntwo\nthree\nfour\nFIVE\n"})
+	plan, err := scaffold.PlanUpgrade(lockfile, scaffoldDir, "", outdir, scaffold.Options{
+		Conflict:       scaffold.ConflictOurs,
+		NonInteractive: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, len(plan.Actions), 1)
+	assert.Equal(t, plan.Actions[0].Kind, scaffold.ActionSkipModified)
+	assert.StrContains(t, plan.Actions[0].Detail, "no git history")
+}
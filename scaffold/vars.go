@@ -1,40 +1,176 @@
 package scaffold
 
 import (
-	"bufio"
 	"fmt"
+	"io"
 	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
 
 	"github.com/olafal0/rescaffold/config"
+	"github.com/olafal0/rescaffold/set"
 )
 
-// LoadVarsInteractive loads vars from lockfile, and prompts the user for any
-// vars that are in the manifest but not in the lockfile.
-func LoadVarsInteractive(manifestVars map[string]*config.ManifestVar, lockfileVars map[string]string) (map[string]string, error) {
+// VarEnvPrefix is the prefix of environment variables that supply var values,
+// followed by the var name in upper case, e.g. RESCAFFOLD_VAR_PROJECT_NAME.
+const VarEnvPrefix = "RESCAFFOLD_VAR_"
+
+// LoadVars determines the value of every var in the manifest, other than
+// derived vars (see DeriveVars). Values are taken, in order of precedence, from:
+//
+//   - opts.Vars
+//   - environment variables (see VarEnvPrefix)
+//   - the lockfile
+//   - user input, or the var's default value if opts.NonInteractive is set
+//
+// Every value is validated against its var's type, and converted to its
+// canonical form. Invalid values from the lockfile are prompted for again,
+// since the scaffold may have changed since they were set; other invalid values
+// are an error. In non-interactive mode, an error listing every var without a
+// valid value is returned.
+func LoadVars(manifestVars map[string]*config.ManifestVar, lockfileVars map[string]string, opts Options) (map[string]string, error) {
+	varNames := set.Keys(manifestVars)
+	sort.Strings(varNames)
+
 	varValues := make(map[string]string, len(manifestVars))
-	for varName, varOptions := range manifestVars {
-		if _, ok := lockfileVars[varName]; ok {
-			varValues[varName] = lockfileVars[varName]
+	missing := []string{}
+	for _, varName := range varNames {
+		varOptions := manifestVars[varName]
+		if varOptions.IsDerived() {
+			// Derived vars are computed by DeriveVars, never set directly
+			if _, ok := opts.Vars[varName]; ok {
+				return nil, fmt.Errorf("cannot set %s: it is derived from other vars", varName)
+			}
 			continue
 		}
-		fmt.Printf("%s: %s\n", varName, varOptions.Description)
-		if varOptions.Default != "" {
-			fmt.Printf("Enter value [%s]: ", varOptions.Default)
-		} else {
-			fmt.Println("Enter value: ")
+		if value, ok := opts.Vars[varName]; ok {
+			parsed, err := varOptions.Parse(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid value for %s: %w", varName, err)
+			}
+			varValues[varName] = parsed
+			continue
+		}
+		if value, ok := os.LookupEnv(VarEnvName(varName)); ok {
+			parsed, err := varOptions.Parse(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid value for %s in %s: %w", varName, VarEnvName(varName), err)
+			}
+			varValues[varName] = parsed
+			continue
+		}
+		if value, ok := lockfileVars[varName]; ok {
+			parsed, err := varOptions.Parse(value)
+			if err == nil {
+				varValues[varName] = parsed
+				continue
+			}
+			if opts.NonInteractive {
+				return nil, fmt.Errorf("value of %s in lockfile is no longer valid: %w", varName, err)
+			}
+			fmt.Printf("value of %s in lockfile is no longer valid: %v\n", varName, err)
+		}
+
+		if opts.NonInteractive {
+			if varOptions.Default == "" {
+				missing = append(missing, varName)
+				continue
+			}
+			parsed, err := varOptions.Parse(varOptions.Default)
+			if err != nil {
+				return nil, fmt.Errorf("invalid default value for %s: %w", varName, err)
+			}
+			varValues[varName] = parsed
+			continue
+		}
+
+		varValue, err := promptVar(varName, varOptions)
+		if err != nil {
+			return nil, err
+		}
+		varValues[varName] = varValue
+	}
+
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("missing values for required vars: %s", strings.Join(missing, ", "))
+	}
+	return varValues, nil
+}
+
+// promptVar prompts the user for the value of a var until a valid value is
+// entered.
+func promptVar(varName string, varOptions *config.ManifestVar) (string, error) {
+	fmt.Printf("%s: %s\n", varName, varOptions.Description)
+	isEnum := varOptions.Type == config.VarTypeEnum
+	if isEnum {
+		for i, enumValue := range varOptions.EnumValues {
+			if enumValue == varOptions.Default {
+				fmt.Printf("  %d) %s (default)\n", i+1, enumValue)
+			} else {
+				fmt.Printf("  %d) %s\n", i+1, enumValue)
+			}
+		}
+	}
+
+	hint := ""
+	if typeHint := varOptions.TypeHint(); typeHint != "" {
+		hint = " (" + typeHint + ")"
+	}
+	for {
+		switch {
+		case isEnum && varOptions.Default != "":
+			fmt.Printf("Choose a value [%s]: ", varOptions.Default)
+		case isEnum:
+			fmt.Print("Choose a value: ")
+		case varOptions.Default != "":
+			fmt.Printf("Enter value%s [%s]: ", hint, varOptions.Default)
+		default:
+			fmt.Printf("Enter value%s: \n", hint)
+		}
+		varValue, err := readLine()
+		if err != nil && err != io.EOF {
+			return "", fmt.Errorf("could not read value for %s: %w", varName, err)
 		}
-		varValue := ""
+		varValue = strings.TrimSpace(varValue)
 
-		stdinScanner := bufio.NewScanner(os.Stdin)
-		stdinScanner.Scan()
-		varValue = stdinScanner.Text()
+		if isEnum {
+			// Enum values can be chosen by their number in the list
+			if i, err := strconv.Atoi(varValue); err == nil && i >= 1 && i <= len(varOptions.EnumValues) {
+				varValue = varOptions.EnumValues[i-1]
+			}
+		}
 		if varValue == "" && varOptions.Default != "" {
 			varValue = varOptions.Default
 		}
 		if varValue == "" && varOptions.Default == "" {
-			return nil, fmt.Errorf("var %s is required", varName)
+			if err == io.EOF {
+				return "", fmt.Errorf("var %s is required", varName)
+			}
+			fmt.Printf("%s is required\n", varName)
+			continue
 		}
-		varValues[varName] = varValue
+
+		parsed, validationErr := varOptions.Parse(varValue)
+		if validationErr == nil {
+			return parsed, nil
+		}
+		if err == io.EOF {
+			// There is no more input, so the value can't be corrected
+			return "", fmt.Errorf("invalid value for %s: %w", varName, validationErr)
+		}
+		fmt.Printf("invalid value: %v\n", validationErr)
 	}
-	return varValues, nil
+}
+
+// VarEnvName returns the name of the environment variable that supplies the
+// value of a var.
+func VarEnvName(varName string) string {
+	return VarEnvPrefix + strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return unicode.ToUpper(r)
+		}
+		return '_'
+	}, varName)
 }
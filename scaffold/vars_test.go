@@ -0,0 +1,98 @@
+package scaffold_test
+
+import (
+	"testing"
+
+	"github.com/olafal0/rescaffold/assert"
+	"github.com/olafal0/rescaffold/config"
+	"github.com/olafal0/rescaffold/scaffold"
+)
+
+func TestLoadVars(t *testing.T) {
+	manifestVars := map[string]*config.ManifestVar{
+		"name": {Type: config.VarTypeString, Default: "default"},
+		"port": {Type: config.VarTypePort},
+	}
+	tests := []struct {
+		desc     string
+		vars     map[string]string
+		env      map[string]string
+		lockfile map[string]string
+		expected map[string]string
+		err      string
+	}{
+		{
+			desc:     "default",
+			vars:     map[string]string{"port": "8080"},
+			expected: map[string]string{"name": "default", "port": "8080"},
+		},
+		{
+			desc:     "lockfile",
+			lockfile: map[string]string{"name": "locked", "port": "8080"},
+			expected: map[string]string{"name": "locked", "port": "8080"},
+		},
+		{
+			desc:     "env overrides lockfile",
+			env:      map[string]string{"RESCAFFOLD_VAR_NAME": "env"},
+			lockfile: map[string]string{"name": "locked", "port": "8080"},
+			expected: map[string]string{"name": "env", "port": "8080"},
+		},
+		{
+			desc:     "flag overrides env and lockfile",
+			vars:     map[string]string{"name": "flag"},
+			env:      map[string]string{"RESCAFFOLD_VAR_NAME": "env"},
+			lockfile: map[string]string{"name": "locked", "port": "8080"},
+			expected: map[string]string{"name": "flag", "port": "8080"},
+		},
+		{
+			desc:     "env replaces invalid lockfile value",
+			env:      map[string]string{"RESCAFFOLD_VAR_PORT": "09000"},
+			lockfile: map[string]string{"port": "none"},
+			expected: map[string]string{"name": "default", "port": "9000"},
+		},
+		{desc: "invalid flag", vars: map[string]string{"port": "none"}, err: "invalid value for port"},
+		{desc: "invalid env", env: map[string]string{"RESCAFFOLD_VAR_PORT": "none"}, err: "RESCAFFOLD_VAR_PORT"},
+		{desc: "invalid lockfile", lockfile: map[string]string{"port": "none"}, err: "no longer valid"},
+		{desc: "missing", err: "missing values for required vars: port"},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			for name, value := range test.env {
+				t.Setenv(name, value)
+			}
+			values, err := scaffold.LoadVars(manifestVars, test.lockfile, scaffold.Options{
+				Vars:           test.vars,
+				NonInteractive: true,
+			})
+			if test.err != "" {
+				if err == nil {
+					t.Fatalf("expected an error, got %v", values)
+				}
+				assert.StrContains(t, err.Error(), test.err)
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			assert.Equal(t, len(values), len(test.expected))
+			for name, value := range test.expected {
+				assert.Equal(t, values[name], value)
+			}
+		})
+	}
+}
+
+func TestLoadVarsDerived(t *testing.T) {
+	manifestVars := map[string]*config.ManifestVar{
+		"name":  {Type: config.VarTypeString},
+		"upper": {Expr: "name|uppercase"},
+	}
+	_, err := scaffold.LoadVars(manifestVars, nil, scaffold.Options{
+		Vars:           map[string]string{"name": "app", "upper": "APP"},
+		NonInteractive: true,
+	})
+	if err == nil {
+		t.Fatal("expected an error setting a derived var")
+	}
+	assert.StrContains(t, err.Error(), "derived")
+}
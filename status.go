@@ -0,0 +1,76 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"log"
+	"os"
+
+	"github.com/olafal0/rescaffold/scaffold"
+)
+
+var statusCommand = &command{
+	name:    "status",
+	args:    "[flags]",
+	summary: "show which generated files have been modified or deleted",
+	description: `Status compares every file tracked in .rescaffold.toml with the file on disk,
+and reports whether it is clean, modified, or missing. With -untracked, it also
+reports files that a scaffold generates which exist but aren't tracked.`,
+	setup: setupStatus,
+}
+
+func setupStatus(flags *flag.FlagSet) func(args []string) error {
+	outputDir := flags.String("out", ".", "directory in which scaffold files are placed")
+	jsonOutput := flags.Bool("json", false, "print the status of each scaffold as JSON")
+	exitCode := flags.Bool("exit-code", false, "exit with status 1 if any file is not clean")
+	untracked := flags.Bool("untracked", false, "load each scaffold at its locked commit to find files it generates that exist but are untracked")
+	offline := flags.Bool("offline", false, "use cached revisions of git scaffolds instead of fetching them")
+	return func(args []string) error {
+		if len(args) > 0 {
+			usageError(flags, "status takes no arguments")
+		}
+		lockfile, err := openLockfile(*outputDir)
+		if err != nil {
+			return err
+		}
+		defer lockfile.Close()
+		statuses, err := scaffold.Status(lockfile, *outputDir, scaffold.StatusOptions{
+			Untracked: *untracked,
+			Offline:   *offline,
+		})
+		if err != nil {
+			return err
+		}
+
+		if *jsonOutput {
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			if err := enc.Encode(statuses); err != nil {
+				return err
+			}
+		} else {
+			for _, status := range statuses {
+				if err := status.WriteTable(os.Stdout); err != nil {
+					return err
+				}
+			}
+		}
+
+		stale, err := scaffold.StaleStagingDirs(*outputDir)
+		if err != nil {
+			return err
+		}
+		for _, dir := range stale {
+			log.Printf("warning: %s was left behind by an interrupted rescaffold run, and may hold backups of your files; delete it once you've checked them\n", dir)
+		}
+
+		if *exitCode {
+			for _, status := range statuses {
+				if !status.Clean() {
+					return errExitStatus
+				}
+			}
+		}
+		return nil
+	}
+}
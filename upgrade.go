@@ -0,0 +1,47 @@
+package main
+
+import (
+	"flag"
+	"sort"
+
+	"github.com/olafal0/rescaffold/config"
+	"github.com/olafal0/rescaffold/scaffold"
+	"github.com/olafal0/rescaffold/set"
+)
+
+var upgradeCommand = &command{
+	name:    "upgrade",
+	args:    "[flags] [source[@ref]...]",
+	summary: "upgrade scaffolds to their latest versions",
+	description: `Upgrade regenerates the given scaffolds, or all scaffolds in the project if
+none are given, from their latest versions. Files you have modified are merged
+with the upstream changes. Use source@ref to upgrade to a specific git ref,
+which is recorded for future upgrades.`,
+	setup: setupUpgrade,
+}
+
+func setupUpgrade(flags *flag.FlagSet) func(args []string) error {
+	pf := addPlanFlags(flags, true)
+	return func(args []string) error {
+		return pf.runPlans(func(lockfile *config.Lockfile, opts scaffold.Options) ([]*scaffold.Plan, error) {
+			return PlanUpgrades(lockfile, args, pf.outputDir, opts)
+		})
+	}
+}
+
+func PlanUpgrades(lockfile *config.Lockfile, scaffolds []string, outdir string, opts scaffold.Options) ([]*scaffold.Plan, error) {
+	if len(scaffolds) == 0 {
+		scaffolds = set.Keys(lockfile.Scaffolds)
+		sort.Strings(scaffolds)
+	}
+	plans := make([]*scaffold.Plan, 0, len(scaffolds))
+	for _, s := range scaffolds {
+		source, ref := scaffold.ParseSource(s)
+		plan, err := scaffold.PlanUpgrade(lockfile, source, ref, outdir, opts)
+		if err != nil {
+			return nil, err
+		}
+		plans = append(plans, plan)
+	}
+	return plans, nil
+}